@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -98,6 +100,65 @@ type Config struct {
 
 	// PprofTagging controls whether span IDs should be propagated to pprof.
 	PprofTagging bool
+
+	// RuntimeDeltas, when true, adds `runtime.*` fields to every root span
+	// (eg those created by the hnynethttp and hnygrpc wrappers) describing
+	// what happened in the Go runtime while the span was running: the number
+	// of GCs, total GC pause time, goroutine counts at start and end, and
+	// bytes allocated by the whole process. Useful for correlating latency
+	// spikes with GC. default: false
+	RuntimeDeltas bool
+
+	// SlowSpanProfiling, if set, captures a CPU profile or goroutine dump of
+	// spans that run longer than a threshold, labeled with their trace and
+	// span IDs, and adds `profile.id`/`profile.path` fields to the span.
+	// Profiling is rate limited globally. See trace.SlowSpanProfiling for the
+	// available options. default: nil (disabled)
+	SlowSpanProfiling *trace.SlowSpanProfiling
+
+	// RuntimeMetricsInterval, if set, enables a collector that sends an event
+	// with `meta.type` of `runtime_metrics` at this interval. Each event
+	// describes the health of the Go runtime: goroutine count, heap in use, GC
+	// count, GC pause and scheduler latency quantiles, and CPU usage as
+	// estimated by the runtime. The collector stops on Close or Shutdown.
+	// default: 0 (disabled)
+	RuntimeMetricsInterval time.Duration
+
+	// ExecutionTracing, when true, opens a runtime/trace task for every span,
+	// annotated with its trace ID, span ID and name, so that execution traces
+	// taken with `go tool trace` can be lined up with Honeycomb traces.
+	// default: false
+	ExecutionTracing bool
+
+	// FlightRecorder, if set, keeps a runtime/trace flight recorder running
+	// and dumps the execution trace when a span finishes slower than its
+	// threshold, adding `trace_dump.id`/`trace_dump.path` fields to the span.
+	// It requires Go 1.25 or later. The flight recorder stops on Close or
+	// Shutdown. See trace.FlightRecording for the available options.
+	// default: nil (disabled)
+	FlightRecorder *trace.FlightRecording
+
+	// BaggageFields, when true, adds the entries of the W3C baggage header an
+	// incoming request carries to its trace as trace-level fields, named
+	// `baggage.` followed by the baggage key. Baggage is propagated to
+	// downstream services either way. default: false
+	BaggageFields bool
+
+	// Propagator, if set, is used by all the HTTP and gRPC wrappers to read
+	// trace context from incoming requests and write it to outgoing ones,
+	// instead of the Honeycomb header (and W3C traceparent, for incoming
+	// requests). Use propagation.NewCompositePropagator to accept and send
+	// several formats. Parser and propagation hooks passed to a wrapper still
+	// take precedence. default: nil
+	Propagator propagation.Propagator
+
+	// PropagatedTraceFields selects the trace level fields sent to
+	// downstream services in trace context headers: an allowlist of field
+	// names, prefixes of field names, and a cap on the size of the Honeycomb
+	// header. Spans that had fields dropped to fit the cap get a
+	// `meta.propagation_truncated` field. Fields added with
+	// AddLocalFieldToTrace are never sent. default: every field is sent
+	PropagatedTraceFields propagation.TraceFieldPolicy
 }
 
 func IsClassicKey(config Config) bool {
@@ -243,6 +304,22 @@ func Init(config Config) {
 		propagation.GlobalConfig.PropagateDataset = false
 	}
 	trace.GlobalConfig.PprofTagging = config.PprofTagging
+	trace.GlobalConfig.RuntimeDeltas = config.RuntimeDeltas
+	trace.GlobalConfig.SlowSpanProfiling = config.SlowSpanProfiling
+	trace.GlobalConfig.ExecutionTracing = config.ExecutionTracing
+	trace.GlobalConfig.BaggageFields = config.BaggageFields
+	propagation.GlobalConfig.Propagator = config.Propagator
+	propagation.GlobalConfig.TraceFields = config.PropagatedTraceFields
+
+	// (re)start the runtime metrics collector after the client fields are set
+	// so its events get them too
+	startRuntimeMetrics(config.RuntimeMetricsInterval)
+	trace.StopFlightRecorder()
+	if config.FlightRecorder != nil {
+		if err := trace.StartFlightRecorder(config.FlightRecorder); err != nil {
+			fmt.Fprintln(os.Stderr, "WARN: Unable to start flight recorder:", err)
+		}
+	}
 	return
 }
 
@@ -258,11 +335,68 @@ func Flush(ctx context.Context) {
 	client.Flush()
 }
 
+// Shutdown gracefully shuts down the beeline. Unlike Close, it first sends every
+// trace that is still in flight in this process, marking each span it sends
+// with `meta.sent_by_shutdown`, and then flushes and closes the libhoney client.
+// It is intended to be called on SIGTERM or similar so that requests being
+// served at shutdown are not lost.
+//
+// Shutdown respects the deadline of ctx. If ctx is done before all in-flight
+// traces have been sent and flushed, Shutdown returns a *ShutdownError that
+// reports how many events were abandoned. As with Close, it is prohibited to try
+// and send an event after the beeline has been shut down.
+func Shutdown(ctx context.Context) error {
+	stopRuntimeMetrics()
+	trace.StopFlightRecorder()
+	sent, abandoned := trace.SendActiveTraces(ctx)
+
+	// grab the client now; if ctx expires, the close keeps running in the
+	// background and must not race with a later Init replacing the client.
+	c := client.Get()
+	closed := make(chan struct{})
+	go func() {
+		c.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		if abandoned == 0 {
+			return nil
+		}
+	case <-ctx.Done():
+		// the spans we just sent may still be sitting in the transmission
+		// queue, so we can't count them as delivered.
+		abandoned += sent
+	}
+	return &ShutdownError{Abandoned: abandoned, Err: ctx.Err()}
+}
+
+// ShutdownError is returned by Shutdown when its context is done before all
+// in-flight traces could be sent and flushed.
+type ShutdownError struct {
+	// Abandoned is the number of spans from in-flight traces that Shutdown
+	// could not confirm were handed off to Honeycomb.
+	Abandoned int
+	// Err is the error from the context that interrupted Shutdown.
+	Err error
+}
+
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("beeline shutdown incomplete, %d events abandoned: %v", e.Abandoned, e.Err)
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
 // Close shuts down the beeline. Closing does not send any pending traces but
 // does flush any pending libhoney events and blocks until they have been sent.
 // It is optional to close the beeline, and prohibited to try and send an event
-// after the beeline has been closed.
+// after the beeline has been closed. Use Shutdown to also send traces that are
+// still in flight.
 func Close() {
+	stopRuntimeMetrics()
+	trace.StopFlightRecorder()
 	client.Close()
 }
 
@@ -315,6 +449,20 @@ func AddFieldToTrace(ctx context.Context, key string, val interface{}) {
 	}
 }
 
+// AddLocalFieldToTrace adds a field to the currently active trace, like
+// AddFieldToTrace, but the field is only added to the spans of this process; it
+// is not passed along to downstream services. Use it for trace level fields
+// that are too large or sensitive to send in trace context headers.
+//
+// Field keys added will be prefixed with 'app.' if the 'app.' prefix is not
+// already present on the key name.
+func AddLocalFieldToTrace(ctx context.Context, key string, val interface{}) {
+	tr := trace.GetTraceFromContext(ctx)
+	if tr != nil {
+		tr.AddLocalField(getNamespacedKey(key), val)
+	}
+}
+
 // getNamespacedKey ensures a key name is prefixed with "app." if the prefix
 // isn't already present. If the key is already namespaced, this reduces the
 // number of memory allocations needed to add a field to a span.
@@ -328,7 +476,9 @@ func getNamespacedKey(key string) string {
 
 // StartSpan lets you start a new span as a child of an already instrumented
 // handler. If there isn't an existing wrapped handler in the context when this
-// is called, it will start a new trace. Spans automatically get a `duration_ms`
+// is called, it will start a new trace, continuing the upstream trace of a
+// request the wrappers didn't trace, such as one dropped by a filter, if
+// there is one in the context. Spans automatically get a `duration_ms`
 // field when they are ended; you should not explicitly set the duration. The
 // name argument will be the primary way the span is identified in the trace
 // view within Honeycomb. You get back a fresh context with the new span in it
@@ -344,13 +494,113 @@ func StartSpan(ctx context.Context, name string) (context.Context, *trace.Span)
 		// there is no trace active; we should make one, but use the root span
 		// as the "new" span instead of creating a child of this mostly empty
 		// span
-		ctx, _ = trace.NewTrace(ctx, nil)
+		ctx, _ = trace.NewTrace(ctx, trace.GetPropagationContextFromContext(ctx))
 		newSpan = trace.GetSpanFromContext(ctx)
 	}
 	newSpan.AddField("name", name)
 	return ctx, newSpan
 }
 
+// Inject writes the trace context of the span in ctx to carrier, so that it
+// can be sent along with a message over any transport, such as a Kafka record
+// or an SQS message. It uses the propagator set in Config.Propagator, or the
+// Honeycomb header if there is none. If there is no span in ctx, the upstream
+// trace context of an untraced request is written instead, if there is one;
+// otherwise Inject does nothing.
+func Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	prop := getPropagationContext(ctx)
+	if prop == nil {
+		return
+	}
+	getPropagator().Inject(prop, carrier)
+}
+
+// Extract starts a new trace from the trace context in carrier, as written by
+// Inject in the sending process. If carrier holds no valid trace context, the
+// new trace gets fresh IDs. You get back a context with the trace in it and
+// its root span; as with StartSpan, you should give the span a name and call
+// `span.Send()` when the unit of work is done.
+func Extract(ctx context.Context, carrier propagation.TextMapCarrier) (context.Context, *trace.Span) {
+	prop, err := getPropagator().Extract(carrier)
+	if err != nil {
+		prop = nil
+	}
+	ctx, tr := trace.NewTraceFromPropagationContext(ctx, prop)
+	return ctx, tr.GetRootSpan()
+}
+
+// InjectEnv adds the trace context of the span in ctx to the environment of
+// cmd, so that a child process instrumented with StartSpanFromEnv continues the
+// trace. The context is set in TRACEPARENT, TRACESTATE, BAGGAGE and
+// HONEYCOMB_TRACE, replacing any values cmd would otherwise inherit. If
+// cmd.Env is nil it is first filled from the current environment, as exec.Cmd
+// would. If there is no span in ctx, the upstream trace context of an
+// untraced request is used instead, if there is one; otherwise InjectEnv does
+// nothing.
+func InjectEnv(ctx context.Context, cmd *exec.Cmd) {
+	prop := getPropagationContext(ctx)
+	if prop == nil {
+		return
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	vars := propagation.MarshalEnvTraceContext(prop)
+	newEnv := make([]string, 0, len(env)+len(vars))
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		if !slices.Contains(propagation.EnvVars, name) {
+			newEnv = append(newEnv, kv)
+		}
+	}
+	for _, name := range propagation.EnvVars {
+		if v, ok := vars[name]; ok {
+			newEnv = append(newEnv, name+"="+v)
+		}
+	}
+	cmd.Env = newEnv
+}
+
+// StartSpanFromEnv starts a new trace that continues the trace context a
+// parent process passed in the environment with InjectEnv. It is meant to be
+// called once, early in a command line program or worker that is run by an
+// instrumented service. If the environment holds no trace context, the new
+// trace gets fresh IDs. The name and return values are as for StartSpan.
+func StartSpanFromEnv(ctx context.Context, name string) (context.Context, *trace.Span) {
+	env := make(map[string]string, len(propagation.EnvVars))
+	for _, k := range propagation.EnvVars {
+		env[k] = os.Getenv(k)
+	}
+	prop, err := propagation.UnmarshalEnvTraceContext(env)
+	if err != nil {
+		prop = nil
+	}
+	ctx, tr := trace.NewTraceFromPropagationContext(ctx, prop)
+	span := tr.GetRootSpan()
+	span.AddField("name", name)
+	return ctx, span
+}
+
+// getPropagationContext returns the propagation context of the span in ctx,
+// or the one kept for an untraced request if there is no span.
+func getPropagationContext(ctx context.Context) *propagation.PropagationContext {
+	if span := trace.GetSpanFromContext(ctx); span != nil {
+		return span.PropagationContext()
+	}
+	prop, _ := trace.GetOutgoingPropagationContextFromContext(ctx)
+	return prop
+}
+
+// getPropagator returns the configured propagator, defaulting to the
+// Honeycomb header format.
+func getPropagator() propagation.Propagator {
+	if p := propagation.GlobalConfig.Propagator; p != nil {
+		return p
+	}
+	return propagation.HoneycombPropagator{}
+}
+
 // readResponses pulls from the response queue and spits them to STDOUT for
 // debugging
 func readResponses(responses chan transmission.Response) {
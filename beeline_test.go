@@ -2,11 +2,18 @@ package beeline
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math"
+	"os/exec"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/honeycombio/libhoney-go/transmission"
 
+	"github.com/honeycombio/beeline-go/propagation"
+	"github.com/honeycombio/beeline-go/trace"
 	libhoney "github.com/honeycombio/libhoney-go"
 	"github.com/stretchr/testify/assert"
 )
@@ -92,6 +99,179 @@ func TestBasicSpanAttributes(t *testing.T) {
 	assert.True(t, foundRoot, "root span missing")
 }
 
+// TestShutdown verifies that Shutdown sends spans that are still in flight and
+// marks them as having been sent by the shutdown.
+func TestShutdown(t *testing.T) {
+	mo := setupLibhoney(t)
+	ctx, _ := StartSpan(context.Background(), "in_flight_root")
+	StartSpan(ctx, "in_flight_child")
+
+	err := Shutdown(context.Background())
+	assert.NoError(t, err)
+
+	var found int
+	for _, ev := range mo.Events() {
+		switch ev.Data["name"] {
+		case "in_flight_root", "in_flight_child":
+			found++
+			assert.Equal(t, true, ev.Data["meta.sent_by_shutdown"], "spans sent by Shutdown should be marked")
+		}
+	}
+	assert.Equal(t, 2, found, "Shutdown should send both in-flight spans")
+}
+
+// TestShutdownDeadline verifies that Shutdown reports abandoned events when its
+// context is already done.
+func TestShutdownDeadline(t *testing.T) {
+	mo := setupLibhoney(t)
+	ctx, _ := StartSpan(context.Background(), "abandoned_root")
+	StartSpan(ctx, "abandoned_child")
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := Shutdown(cancelled)
+
+	var shutdownErr *ShutdownError
+	assert.True(t, errors.As(err, &shutdownErr), "Shutdown should return a ShutdownError")
+	assert.GreaterOrEqual(t, shutdownErr.Abandoned, 2, "both in-flight spans should be abandoned")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 0, len(mo.Events()), "no spans should be sent after the deadline")
+}
+
+// TestInjectExtract verifies that trace context injected into a message
+// carrier continues the trace when extracted on the other side.
+func TestInjectExtract(t *testing.T) {
+	mo := setupLibhoney(t)
+	ctx, producer := StartSpan(context.Background(), "produce")
+	AddFieldToTrace(ctx, "tenant", "acme")
+	AddLocalFieldToTrace(ctx, "session", "s3cret")
+	headers := propagation.BytesMapCarrier{}
+	Inject(ctx, headers)
+	producer.Send()
+	assert.Contains(t, headers, propagation.TracePropagationHTTPHeader)
+
+	_, consumer := Extract(context.Background(), headers)
+	consumer.AddField("name", "consume")
+	consumer.Send()
+	assert.Equal(t, producer.GetTrace().GetTraceID(), consumer.GetTrace().GetTraceID())
+	assert.Equal(t, producer.GetSpanID(), consumer.GetParentID())
+
+	evs := mo.Events()
+	assert.Equal(t, 2, len(evs))
+	assert.Equal(t, "acme", evs[1].Data["app.tenant"], "trace fields should be propagated")
+	assert.Equal(t, "s3cret", evs[0].Data["app.session"])
+	assert.Nil(t, evs[1].Data["app.session"], "local trace fields should not be propagated")
+
+	// no span to inject, nothing to extract
+	attrs := propagation.MapCarrier{}
+	Inject(context.Background(), attrs)
+	assert.Empty(t, attrs)
+	_, root := Extract(context.Background(), attrs)
+	assert.NotEmpty(t, root.GetTrace().GetTraceID())
+	assert.Empty(t, root.GetParentID())
+
+	// with a configured propagator
+	propagation.GlobalConfig.Propagator = propagation.W3CPropagator{}
+	defer func() { propagation.GlobalConfig.Propagator = nil }()
+	Inject(ctx, attrs)
+	assert.Contains(t, attrs, "traceparent")
+	_, consumer = Extract(context.Background(), attrs)
+	assert.Equal(t, producer.GetTrace().GetTraceID(), consumer.GetTrace().GetTraceID())
+}
+
+// TestUntracedPropagation verifies that the trace context of an untraced
+// request is passed on and continued by StartSpan.
+func TestUntracedPropagation(t *testing.T) {
+	setupLibhoney(t)
+	prop := &propagation.PropagationContext{TraceID: "0af7651916cd43dd8448eb211c80319c", ParentID: "b7ad6b7169203331"}
+	ctx := trace.PutPropagationContextInContext(context.Background(), prop)
+
+	headers := propagation.MapCarrier{}
+	Inject(ctx, headers)
+	out, err := propagation.UnmarshalHoneycombTraceContext(headers[propagation.TracePropagationHTTPHeader])
+	assert.NoError(t, err)
+	assert.Equal(t, prop.TraceID, out.TraceID)
+	assert.Equal(t, prop.ParentID, out.ParentID)
+
+	_, span := StartSpan(ctx, "work")
+	assert.Equal(t, prop.TraceID, span.GetTrace().GetTraceID())
+	assert.Equal(t, prop.ParentID, span.GetParentID())
+	span.Send()
+}
+
+// TestEnvPropagation verifies that trace context injected into a command's
+// environment continues the trace in StartSpanFromEnv.
+func TestEnvPropagation(t *testing.T) {
+	setupLibhoney(t)
+	ctx, parent := StartSpan(context.Background(), "parent")
+	defer parent.Send()
+
+	cmd := exec.Command("worker")
+	cmd.Env = []string{"PATH=/bin", "TRACEPARENT=00-11111111111111111111111111111111-1111111111111111-01", "TRACESTATE=stale=1"}
+	InjectEnv(ctx, cmd)
+	env := make(map[string]string)
+	for _, kv := range cmd.Env {
+		k, v, _ := strings.Cut(kv, "=")
+		_, dup := env[k]
+		assert.False(t, dup, "variable %s should only be set once", k)
+		env[k] = v
+	}
+	assert.Equal(t, "/bin", env["PATH"])
+	assert.Contains(t, env["TRACEPARENT"], parent.GetSpanID())
+	assert.NotContains(t, env, "TRACESTATE", "inherited trace state should be removed")
+	assert.Equal(t, parent.SerializeHeaders(), env["HONEYCOMB_TRACE"])
+
+	for k, v := range env {
+		t.Setenv(k, v)
+	}
+	_, child := StartSpanFromEnv(context.Background(), "worker")
+	assert.Equal(t, parent.GetTrace().GetTraceID(), child.GetTrace().GetTraceID())
+	assert.Equal(t, parent.GetSpanID(), child.GetParentID())
+
+	// with no span, the environment is untouched
+	cmd = exec.Command("worker")
+	InjectEnv(context.Background(), cmd)
+	assert.Nil(t, cmd.Env)
+}
+
+// TestRuntimeMetrics verifies that the runtime metrics collector sends events
+// with runtime health fields and stops on Close.
+func TestRuntimeMetrics(t *testing.T) {
+	mo := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:       "placeholder",
+		Dataset:      "placeholder",
+		APIHost:      "placeholder",
+		Transmission: mo,
+	})
+	assert.Equal(t, nil, err)
+	Init(Config{Client: client, ServiceName: "svc", RuntimeMetricsInterval: 5 * time.Millisecond})
+
+	assert.Eventually(t, func() bool {
+		return len(mo.Events()) > 0
+	}, time.Second, 5*time.Millisecond, "collector should send an event")
+	Close()
+	sent := len(mo.Events())
+
+	fields := mo.Events()[0].Data
+	assert.Equal(t, "runtime_metrics", fields["meta.type"])
+	assert.Equal(t, "svc", fields["service_name"], "runtime metrics should carry client fields")
+	assert.Contains(t, fields, "runtime.goroutines")
+	assert.Contains(t, fields, "runtime.heap_inuse_bytes")
+	assert.Contains(t, fields, "runtime.gc_count")
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, sent, len(mo.Events()), "collector should stop on Close")
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	buckets := []float64{0, 1, 2, 3, math.Inf(1)}
+	counts := []uint64{5, 3, 0, 2}
+	assert.Equal(t, float64(1), histogramQuantile(counts, buckets, 10, 0.5))
+	assert.Equal(t, float64(2), histogramQuantile(counts, buckets, 10, 0.8))
+	assert.Equal(t, float64(3), histogramQuantile(counts, buckets, 10, 1))
+}
+
 func BenchmarkCreateSpan(b *testing.B) {
 	setupLibhoney(b)
 
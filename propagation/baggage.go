@@ -0,0 +1,338 @@
+package propagation
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// baggage contains types and functions for the W3C Baggage header, which
+// carries application defined key/value pairs alongside the trace context. See
+// https://www.w3.org/TR/baggage/
+
+const (
+	BaggageHeader = "baggage"
+
+	// maxBaggageMembers and maxBaggageBytes are the limits on the baggage
+	// header. They match the limits used by the OpenTelemetry SDKs, which are
+	// above the minimum the specification requires implementations to
+	// propagate.
+	maxBaggageMembers = 180
+	maxBaggageBytes   = 8192
+
+	baggageListDelimiter     = ","
+	baggagePropertyDelimiter = ";"
+	baggageKeyValueDelimiter = "="
+
+	errInvalidBaggageKey      errorConst = "invalid baggage key"
+	errInvalidBaggageValue    errorConst = "invalid baggage value"
+	errInvalidBaggageMember   errorConst = "invalid baggage list-member"
+	errInvalidBaggageProperty errorConst = "invalid baggage property"
+	errBaggageMemberNumber    errorConst = "too many list-members in baggage"
+	errBaggageBytes           errorConst = "baggage is too large"
+)
+
+// BaggageProperty is a property attached to a baggage list-member. A property
+// is a key, optionally with a value.
+type BaggageProperty struct {
+	Key   string
+	Value string
+	// HasValue is false for properties that are just a key, eg `ephemeral`.
+	HasValue bool
+}
+
+// String encodes the property in the format used by the baggage header.
+func (p BaggageProperty) String() string {
+	if !p.HasValue {
+		return p.Key
+	}
+	return p.Key + baggageKeyValueDelimiter + encodeBaggageValue(p.Value)
+}
+
+// BaggageMember is a single key/value pair in the baggage, with its
+// properties. Values are held decoded; they are percent-encoded when the
+// baggage is serialized.
+type BaggageMember struct {
+	Key        string
+	Value      string
+	Properties []BaggageProperty
+}
+
+// String encodes the member in the format used by the baggage header.
+func (m BaggageMember) String() string {
+	s := m.Key + baggageKeyValueDelimiter + encodeBaggageValue(m.Value)
+	for _, p := range m.Properties {
+		s += baggagePropertyDelimiter + p.String()
+	}
+	return s
+}
+
+func (m BaggageMember) validate() error {
+	if !isBaggageToken(m.Key) {
+		return fmt.Errorf("%w: %q", errInvalidBaggageKey, m.Key)
+	}
+	if !utf8.ValidString(m.Value) {
+		return fmt.Errorf("%w: %q", errInvalidBaggageValue, m.Value)
+	}
+	for _, p := range m.Properties {
+		if !isBaggageToken(p.Key) {
+			return fmt.Errorf("%w: %q", errInvalidBaggageProperty, p.Key)
+		}
+		if !utf8.ValidString(p.Value) {
+			return fmt.Errorf("%w: %q", errInvalidBaggageProperty, p.Value)
+		}
+	}
+	return nil
+}
+
+// Baggage is an immutable list of list-members conforming to the W3C Baggage
+// specification. All operations that create a Baggage validate their input,
+// and keep the baggage within the size limits.
+type Baggage struct {
+	list []BaggageMember
+}
+
+// ParseBaggage decodes a Baggage from the value of a baggage header. It
+// returns an error if the value is invalid according to the W3C Baggage
+// specification or exceeds the size limits.
+func ParseBaggage(baggage string) (Baggage, error) {
+	if baggage == "" {
+		return Baggage{}, nil
+	}
+	wrapErr := func(err error) error {
+		return fmt.Errorf("failed to parse baggage: %w", err)
+	}
+	if len(baggage) > maxBaggageBytes {
+		return Baggage{}, wrapErr(errBaggageBytes)
+	}
+
+	var b Baggage
+	for _, memberStr := range strings.Split(baggage, baggageListDelimiter) {
+		if strings.TrimSpace(memberStr) == "" {
+			continue
+		}
+		m, err := parseBaggageMember(memberStr)
+		if err != nil {
+			return Baggage{}, wrapErr(err)
+		}
+		// later members with the same key win
+		b = b.Delete(m.Key)
+		b.list = append(b.list, m)
+		if len(b.list) > maxBaggageMembers {
+			return Baggage{}, wrapErr(errBaggageMemberNumber)
+		}
+	}
+	return b, nil
+}
+
+func parseBaggageMember(s string) (BaggageMember, error) {
+	parts := strings.Split(s, baggagePropertyDelimiter)
+	key, value, ok := strings.Cut(parts[0], baggageKeyValueDelimiter)
+	if !ok {
+		return BaggageMember{}, fmt.Errorf("%w: %q", errInvalidBaggageMember, s)
+	}
+	m := BaggageMember{Key: strings.TrimSpace(key)}
+	if !isBaggageToken(m.Key) {
+		return BaggageMember{}, fmt.Errorf("%w: %q", errInvalidBaggageKey, m.Key)
+	}
+	var err error
+	if m.Value, err = decodeBaggageValue(strings.TrimSpace(value)); err != nil {
+		return BaggageMember{}, err
+	}
+
+	for _, propStr := range parts[1:] {
+		var p BaggageProperty
+		key, value, p.HasValue = strings.Cut(propStr, baggageKeyValueDelimiter)
+		p.Key = strings.TrimSpace(key)
+		if !isBaggageToken(p.Key) {
+			return BaggageMember{}, fmt.Errorf("%w: %q", errInvalidBaggageProperty, propStr)
+		}
+		if p.HasValue {
+			if p.Value, err = decodeBaggageValue(strings.TrimSpace(value)); err != nil {
+				return BaggageMember{}, err
+			}
+		}
+		m.Properties = append(m.Properties, p)
+	}
+	return m, nil
+}
+
+// String encodes the Baggage into a value for the baggage header.
+func (b Baggage) String() string {
+	members := make([]string, len(b.list))
+	for i, m := range b.list {
+		members[i] = m.String()
+	}
+	return strings.Join(members, baggageListDelimiter)
+}
+
+// Len returns the number of list-members in the Baggage.
+func (b Baggage) Len() int {
+	return len(b.list)
+}
+
+// Members returns a copy of the list-members of the Baggage, in order.
+func (b Baggage) Members() []BaggageMember {
+	members := make([]BaggageMember, len(b.list))
+	copy(members, b.list)
+	return members
+}
+
+// Member returns the list-member with the given key, if there is one.
+func (b Baggage) Member(key string) (BaggageMember, bool) {
+	for _, m := range b.list {
+		if m.Key == key {
+			return m, true
+		}
+	}
+	return BaggageMember{}, false
+}
+
+// Get returns the value of the list-member with the given key, or an empty
+// string if there is none.
+func (b Baggage) Get(key string) string {
+	m, _ := b.Member(key)
+	return m.Value
+}
+
+// Set returns a copy of the Baggage with the list-member for key set to value,
+// replacing any existing member with that key. It returns an error and the
+// original Baggage if the member is invalid, or if the Baggage would be too
+// large to propagate with it.
+func (b Baggage) Set(key, value string, properties ...BaggageProperty) (Baggage, error) {
+	m := BaggageMember{Key: key, Value: value, Properties: properties}
+	if err := m.validate(); err != nil {
+		return b, err
+	}
+	cb := b.Delete(key)
+	cb.list = append(cb.list, m)
+	if len(cb.list) > maxBaggageMembers {
+		return b, errBaggageMemberNumber
+	}
+	if len(cb.String()) > maxBaggageBytes {
+		return b, errBaggageBytes
+	}
+	return cb, nil
+}
+
+// Delete returns a copy of the Baggage with the list-member for key removed.
+func (b Baggage) Delete(key string) Baggage {
+	members := make([]BaggageMember, 0, len(b.list))
+	for _, m := range b.list {
+		if m.Key != key {
+			members = append(members, m)
+		}
+	}
+	return Baggage{list: members}
+}
+
+// MarshalW3CBaggage uses the baggage in prop to create a W3C baggage header.
+// The header is returned in a map[string]string, ready to be added to the
+// headers of an outbound request.
+//
+// If prop is nil or has no baggage, the return value will be an empty map.
+func MarshalW3CBaggage(prop *PropagationContext) map[string]string {
+	headerMap := make(map[string]string)
+	if prop == nil || prop.Baggage.Len() == 0 {
+		return headerMap
+	}
+	headerMap[BaggageHeader] = prop.Baggage.String()
+	return headerMap
+}
+
+// UnmarshalW3CBaggage parses the W3C baggage header in headers. An absent
+// header results in empty baggage. If the header is invalid or too large, an
+// error is returned along with empty baggage.
+func UnmarshalW3CBaggage(headers map[string]string) (Baggage, error) {
+	return ParseBaggage(getHeaderValue(headers, BaggageHeader))
+}
+
+// isBaggageToken reports whether s is a token as defined by RFC 7230, which is
+// what baggage keys must be.
+func isBaggageToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isBaggageOctet reports whether c may appear in a baggage value without
+// being percent-encoded.
+func isBaggageOctet(c byte) bool {
+	return c == 0x21 ||
+		(0x23 <= c && c <= 0x2b) ||
+		(0x2d <= c && c <= 0x3a) ||
+		(0x3c <= c && c <= 0x5b) ||
+		(0x5d <= c && c <= 0x7e)
+}
+
+// encodeBaggageValue percent-encodes every byte of value that is not allowed
+// in a baggage value. This includes '%' itself.
+func encodeBaggageValue(value string) string {
+	const hexDigits = "0123456789ABCDEF"
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if isBaggageOctet(c) && c != '%' {
+			sb.WriteByte(c)
+			continue
+		}
+		sb.WriteByte('%')
+		sb.WriteByte(hexDigits[c>>4])
+		sb.WriteByte(hexDigits[c&0xf])
+	}
+	return sb.String()
+}
+
+// decodeBaggageValue validates a value from a baggage header and decodes its
+// percent-encoded bytes. The decoded value must be valid UTF-8.
+func decodeBaggageValue(value string) (string, error) {
+	invalid := fmt.Errorf("%w: %q", errInvalidBaggageValue, value)
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == '%' {
+			if i+2 >= len(value) {
+				return "", invalid
+			}
+			hi, ok1 := unhex(value[i+1])
+			lo, ok2 := unhex(value[i+2])
+			if !ok1 || !ok2 {
+				return "", invalid
+			}
+			sb.WriteByte(hi<<4 | lo)
+			i += 2
+			continue
+		}
+		if !isBaggageOctet(c) {
+			return "", invalid
+		}
+		sb.WriteByte(c)
+	}
+	decoded := sb.String()
+	if !utf8.ValidString(decoded) {
+		return "", invalid
+	}
+	return decoded, nil
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
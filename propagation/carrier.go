@@ -0,0 +1,150 @@
+package propagation
+
+import (
+	"net/http"
+	"strings"
+)
+
+// TextMapCarrier is the storage medium a Propagator reads trace context
+// headers from and writes them to, such as the headers of an HTTP request or
+// the metadata of a gRPC call.
+type TextMapCarrier interface {
+	// Get returns the value associated with key, or an empty string.
+	Get(key string) string
+	// Set stores the key/value pair, replacing any existing value.
+	Set(key string, value string)
+	// Keys lists the keys stored in the carrier.
+	Keys() []string
+}
+
+// HeaderCarrier adapts http.Header to the TextMapCarrier interface.
+type HeaderCarrier http.Header
+
+// Get returns the first value associated with key.
+func (hc HeaderCarrier) Get(key string) string {
+	return http.Header(hc).Get(key)
+}
+
+// Set stores the key/value pair.
+func (hc HeaderCarrier) Set(key string, value string) {
+	http.Header(hc).Set(key, value)
+}
+
+// Keys lists the keys stored in the carrier.
+func (hc HeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(hc))
+	for k := range hc {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// MetadataCarrier adapts gRPC metadata to the TextMapCarrier interface. A
+// metadata.MD can be converted to it directly:
+//
+//	propagation.MetadataCarrier(md)
+type MetadataCarrier map[string][]string
+
+// Get returns the first value associated with key.
+func (mc MetadataCarrier) Get(key string) string {
+	if v := mc[strings.ToLower(key)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// Set stores the key/value pair. Keys are lowercased, as gRPC requires.
+func (mc MetadataCarrier) Set(key string, value string) {
+	mc[strings.ToLower(key)] = []string{value}
+}
+
+// Keys lists the keys stored in the carrier.
+func (mc MetadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(mc))
+	for k := range mc {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// MapCarrier adapts a map[string]string, such as the attributes of a queue
+// message, to the TextMapCarrier interface.
+type MapCarrier map[string]string
+
+// Get returns the value associated with key. If there is no exact match, keys
+// are compared case insensitively.
+func (mc MapCarrier) Get(key string) string {
+	if v, ok := mc[key]; ok {
+		return v
+	}
+	for k, v := range mc {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+// Set stores the key/value pair.
+func (mc MapCarrier) Set(key string, value string) {
+	mc[key] = value
+}
+
+// Keys lists the keys stored in the carrier.
+func (mc MapCarrier) Keys() []string {
+	keys := make([]string, 0, len(mc))
+	for k := range mc {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// BytesMapCarrier adapts message headers with []byte values, as used by
+// Kafka and AMQP clients, to the TextMapCarrier interface.
+type BytesMapCarrier map[string][]byte
+
+// Get returns the value associated with key. If there is no exact match, keys
+// are compared case insensitively.
+func (bc BytesMapCarrier) Get(key string) string {
+	if v, ok := bc[key]; ok {
+		return string(v)
+	}
+	for k, v := range bc {
+		if strings.EqualFold(k, key) {
+			return string(v)
+		}
+	}
+	return ""
+}
+
+// Set stores the key/value pair.
+func (bc BytesMapCarrier) Set(key string, value string) {
+	bc[key] = []byte(value)
+}
+
+// Keys lists the keys stored in the carrier.
+func (bc BytesMapCarrier) Keys() []string {
+	keys := make([]string, 0, len(bc))
+	for k := range bc {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// carrierHeaders flattens a carrier into the map with lowercase header names
+// expected by the unmarshal functions.
+func carrierHeaders(carrier TextMapCarrier) map[string]string {
+	keys := carrier.Keys()
+	headers := make(map[string]string, len(keys))
+	for _, k := range keys {
+		headers[strings.ToLower(k)] = carrier.Get(k)
+	}
+	return headers
+}
+
+// setHeaders stores each of headers in the carrier.
+func setHeaders(carrier TextMapCarrier, headers map[string]string) {
+	for k, v := range headers {
+		carrier.Set(k, v)
+	}
+}
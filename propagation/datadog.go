@@ -0,0 +1,140 @@
+package propagation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// this file contains functions for parsing and generating Datadog headers.
+// Datadog IDs are unsigned 64-bit decimal numbers. 128-bit trace IDs carry
+// their upper 64 bits as hex in the _dd.p.tid propagated tag.
+// the only exported functions are MarshalDatadogTraceContext and UnmarshalDatadogTraceContext
+
+const (
+	DatadogTraceIDHeader          = "x-datadog-trace-id"
+	DatadogParentIDHeader         = "x-datadog-parent-id"
+	DatadogSamplingPriorityHeader = "x-datadog-sampling-priority"
+	DatadogTagsHeader             = "x-datadog-tags"
+
+	datadogTraceIDUpperTag = "_dd.p.tid"
+)
+
+var (
+	errInvalidDatadogTraceID  = errors.New("invalid Datadog trace id found")
+	errInvalidDatadogParentID = errors.New("invalid Datadog parent id found")
+	errInvalidDatadogPriority = errors.New("invalid Datadog sampling priority found")
+)
+
+// MarshalDatadogTraceContext uses the information in prop to create Datadog
+// trace headers. The lower 64 bits of the trace ID are sent in
+// x-datadog-trace-id and, if they are not zero, the upper 64 bits in the
+// _dd.p.tid tag of x-datadog-tags. The sampled flag is sent as a sampling
+// priority of 1 or 0. The headers are returned as a map[string]string.
+//
+// Context is passed into this function and returned to match the other
+// propagation formats. If it carries a deferred sampling decision, as set by
+// UnmarshalDatadogTraceContext when no sampling priority was received, no
+// sampling priority is sent either.
+//
+// If prop is empty, nil or does not have valid trace and span IDs, the return
+// value will be an empty map.
+func MarshalDatadogTraceContext(ctx context.Context, prop *PropagationContext) (context.Context, map[string]string) {
+	headerMap := make(map[string]string)
+	if prop == nil {
+		return ctx, headerMap
+	}
+
+	traceID, err := traceIDFromHex(prop.TraceID)
+	if err != nil {
+		return ctx, headerMap
+	}
+	spanID, err := spanIDFromHex(prop.ParentID)
+	if err != nil {
+		return ctx, headerMap
+	}
+	upper := traceID.String()[:16]
+	lower, _ := strconv.ParseUint(traceID.String()[16:], 16, 64)
+	parent, _ := strconv.ParseUint(spanID.String(), 16, 64)
+	if lower == 0 {
+		// Datadog does not accept a zero trace id
+		return ctx, headerMap
+	}
+
+	headerMap[DatadogTraceIDHeader] = strconv.FormatUint(lower, 10)
+	headerMap[DatadogParentIDHeader] = strconv.FormatUint(parent, 10)
+	if upper != b3TraceIDPadding {
+		headerMap[DatadogTagsHeader] = datadogTraceIDUpperTag + "=" + upper
+	}
+	if !deferredFromContext(ctx) {
+		if prop.TraceFlags.IsSampled() {
+			headerMap[DatadogSamplingPriorityHeader] = "1"
+		} else {
+			headerMap[DatadogSamplingPriorityHeader] = "0"
+		}
+	}
+	return ctx, headerMap
+}
+
+// UnmarshalDatadogTraceContext parses the information provided in the Datadog
+// headers and creates a PropagationContext instance with 128-bit hex trace and
+// 64-bit hex parent IDs. Header names in headers are expected to be lowercase.
+// Sampling priorities above zero (auto and user keep) set the sampled flag.
+//
+// Context is passed into this function and returned so that a missing
+// sampling priority can be carried through to MarshalDatadogTraceContext or
+// MarshalB3TraceContext as a deferred sampling decision.
+//
+// If the headers do not contain a valid trace id and parent id, an error will
+// be returned.
+func UnmarshalDatadogTraceContext(ctx context.Context, headers map[string]string) (context.Context, *PropagationContext, error) {
+	traceIDHeader := getHeaderValue(headers, DatadogTraceIDHeader)
+	parentIDHeader := getHeaderValue(headers, DatadogParentIDHeader)
+	if traceIDHeader == "" && parentIDHeader == "" {
+		return ctx, nil, errors.New("cannot unmarshal empty header")
+	}
+
+	lower, err := strconv.ParseUint(strings.TrimSpace(traceIDHeader), 10, 64)
+	if err != nil || lower == 0 {
+		return ctx, nil, errInvalidDatadogTraceID
+	}
+	parent, err := strconv.ParseUint(strings.TrimSpace(parentIDHeader), 10, 64)
+	if err != nil || parent == 0 {
+		return ctx, nil, errInvalidDatadogParentID
+	}
+
+	// an invalid upper half is ignored, as the Datadog tracers do, leaving a
+	// 64-bit trace id
+	upper := b3TraceIDPadding
+	for _, tag := range strings.Split(getHeaderValue(headers, DatadogTagsHeader), ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(tag), "=")
+		if !ok || key != datadogTraceIDUpperTag {
+			continue
+		}
+		if _, err := strconv.ParseUint(value, 16, 64); err == nil && len(value) == 16 {
+			upper = strings.ToLower(value)
+		}
+	}
+
+	prop := &PropagationContext{
+		TraceID:  upper + fmt.Sprintf("%016x", lower),
+		ParentID: fmt.Sprintf("%016x", parent),
+	}
+
+	priority := strings.TrimSpace(getHeaderValue(headers, DatadogSamplingPriorityHeader))
+	if priority == "" {
+		ctx = withDeferred(ctx, true)
+	} else {
+		p, err := strconv.Atoi(priority)
+		if err != nil {
+			return ctx, nil, errInvalidDatadogPriority
+		}
+		if p > 0 {
+			prop.TraceFlags = FlagsSampled
+		}
+	}
+
+	return ctx, prop, nil
+}
@@ -0,0 +1,82 @@
+package propagation
+
+import (
+	"context"
+	"errors"
+)
+
+// this file contains functions for passing trace context to child processes
+// in environment variables. The W3C variables follow the names proposed for
+// the OpenTelemetry environment variable carrier, and the Honeycomb header is
+// carried in HONEYCOMB_TRACE.
+// the only exported functions are MarshalEnvTraceContext and UnmarshalEnvTraceContext
+
+const (
+	TraceparentEnv    = "TRACEPARENT"
+	TracestateEnv     = "TRACESTATE"
+	BaggageEnv        = "BAGGAGE"
+	HoneycombTraceEnv = "HONEYCOMB_TRACE"
+)
+
+// EnvVars lists the environment variables used to propagate trace context, so
+// that stale values inherited from a parent process can be removed before new
+// ones are set.
+var EnvVars = []string{TraceparentEnv, TracestateEnv, BaggageEnv, HoneycombTraceEnv}
+
+// MarshalEnvTraceContext uses the information in prop to create the
+// environment variables for a child process. It returns a map of variable name
+// to value, holding the Honeycomb header in HONEYCOMB_TRACE, the W3C trace
+// context in TRACEPARENT and TRACESTATE, and the W3C baggage in BAGGAGE.
+// Variables that would be empty are left out.
+//
+// If prop is nil, the return value will be an empty map.
+func MarshalEnvTraceContext(prop *PropagationContext) map[string]string {
+	env := make(map[string]string)
+	if prop == nil {
+		return env
+	}
+	env[HoneycombTraceEnv] = MarshalHoneycombTraceContext(prop)
+	_, headers := MarshalW3CTraceContext(context.Background(), prop)
+	if v := headers[TraceparentHeader]; v != "" {
+		env[TraceparentEnv] = v
+	}
+	if v := headers[TracestateHeader]; v != "" {
+		env[TracestateEnv] = v
+	}
+	if prop.Baggage.Len() > 0 {
+		env[BaggageEnv] = prop.Baggage.String()
+	}
+	return env
+}
+
+// UnmarshalEnvTraceContext parses the trace context environment variables in
+// env, a map of variable name to value, and creates a PropagationContext
+// instance. HONEYCOMB_TRACE is preferred, as it carries the trace level
+// fields; otherwise TRACEPARENT is used. TRACESTATE and BAGGAGE are read in
+// either case, and invalid values for them are ignored.
+//
+// If neither HONEYCOMB_TRACE nor TRACEPARENT hold a valid trace context, an
+// error will be returned.
+func UnmarshalEnvTraceContext(env map[string]string) (*PropagationContext, error) {
+	headers := map[string]string{
+		TraceparentHeader: env[TraceparentEnv],
+		TracestateHeader:  env[TracestateEnv],
+		BaggageHeader:     env[BaggageEnv],
+	}
+	if h := env[HoneycombTraceEnv]; h != "" {
+		prop, err := UnmarshalHoneycombTraceContext(h)
+		if err == nil {
+			prop.TraceState, _ = ParseTraceState(headers[TracestateHeader])
+			prop.Baggage, _ = ParseBaggage(headers[BaggageHeader])
+			return prop, nil
+		}
+	}
+	if headers[TraceparentHeader] == "" {
+		return nil, errors.New("no trace context found in environment")
+	}
+	_, prop, err := UnmarshalW3CTraceContext(context.Background(), headers)
+	if err != nil {
+		return nil, err
+	}
+	return prop, nil
+}
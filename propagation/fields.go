@@ -0,0 +1,104 @@
+package propagation
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// TraceFieldPolicy selects the trace level fields that are sent downstream in
+// the trace context of outgoing requests. Fields that aren't sent are still
+// added to every span of the trace. The zero TraceFieldPolicy sends every
+// field.
+type TraceFieldPolicy struct {
+	// Allow lists the names of the fields that are sent, eg "app.user_id".
+	Allow []string
+	// Prefixes lists prefixes of the names of fields that are sent, eg
+	// "app.tenant.". If neither Allow nor Prefixes is set, every field is
+	// sent.
+	Prefixes []string
+	// MaxHeaderBytes caps the size of the Honeycomb trace header, which
+	// proxies often limit to around 8KB in total. If the fields would take
+	// it over, fields are dropped, largest first, until it fits. Fields of
+	// equal size are dropped in reverse order of their names. The W3C
+	// headers carry no fields, but other propagators get the same fields.
+	MaxHeaderBytes int
+}
+
+// Apply removes from prop.TraceContext the fields p doesn't allow, and then
+// drops fields until prop encodes to a Honeycomb header within
+// p.MaxHeaderBytes. It reports whether any fields were dropped for size.
+// prop.TraceContext is replaced, not modified.
+func (p TraceFieldPolicy) Apply(prop *PropagationContext) (truncated bool) {
+	if len(prop.TraceContext) == 0 || (len(p.Allow) == 0 && len(p.Prefixes) == 0 && p.MaxHeaderBytes <= 0) {
+		return false
+	}
+	fields := make(map[string]interface{}, len(prop.TraceContext))
+	for k, v := range prop.TraceContext {
+		if p.allows(k) {
+			fields[k] = v
+		}
+	}
+	prop.TraceContext = fields
+	if p.MaxHeaderBytes <= 0 || len(fields) == 0 {
+		return false
+	}
+
+	// the encoded size of each field, as "key":value
+	type field struct {
+		key  string
+		size int
+	}
+	sizes := make([]field, 0, len(fields))
+	jsonLen := len("{}") - 1
+	for k, v := range fields {
+		key, _ := json.Marshal(k)
+		value, err := json.Marshal(v)
+		if err != nil {
+			// the whole context would fail to encode
+			delete(fields, k)
+			continue
+		}
+		f := field{k, len(key) + 1 + len(value)}
+		sizes = append(sizes, f)
+		jsonLen += f.size + 1 // and a comma
+	}
+	sort.Slice(sizes, func(i, j int) bool {
+		if sizes[i].size != sizes[j].size {
+			return sizes[i].size > sizes[j].size
+		}
+		return sizes[i].key > sizes[j].key
+	})
+
+	empty := *prop
+	empty.TraceContext = map[string]interface{}{}
+	headerLen := len(MarshalHoneycombTraceContext(&empty)) - base64.StdEncoding.EncodedLen(len("{}"))
+	for _, f := range sizes {
+		if headerLen+base64.StdEncoding.EncodedLen(max(jsonLen, len("{}"))) <= p.MaxHeaderBytes {
+			break
+		}
+		delete(fields, f.key)
+		jsonLen -= f.size + 1
+		truncated = true
+	}
+	return truncated
+}
+
+// allows reports whether p allows the field named key to be sent.
+func (p TraceFieldPolicy) allows(key string) bool {
+	if len(p.Allow) == 0 && len(p.Prefixes) == 0 {
+		return true
+	}
+	for _, name := range p.Allow {
+		if key == name {
+			return true
+		}
+	}
+	for _, prefix := range p.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -70,7 +70,7 @@ func MarshalHoneycombTraceContext(prop *PropagationContext) string {
 func UnmarshalHoneycombTraceContext(header string) (*PropagationContext, error) {
 	// pull the version out of the header
 	getVer := strings.SplitN(header, ";", 2)
-	if getVer[0] == "1" {
+	if getVer[0] == "1" && len(getVer) == 2 {
 		return unmarshalHoneycombTraceContextV1(getVer[1])
 	}
 	return nil, &PropagationError{fmt.Sprintf("unrecognized version for trace header %s", getVer[0]), nil}
@@ -0,0 +1,186 @@
+package propagation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// this file contains functions for parsing and generating Jaeger headers, as
+// described here:
+// https://www.jaegertracing.io/docs/1.21/client-libraries/#propagation-format
+// the only exported functions are MarshalJaegerTraceContext and UnmarshalJaegerTraceContext
+
+const (
+	// JaegerTraceHeader is the header holding the Jaeger trace context, in the
+	// form {trace-id}:{span-id}:{parent-span-id}:{flags}.
+	JaegerTraceHeader = "uber-trace-id"
+	// JaegerBaggageHeaderPrefix is the prefix of the headers holding Jaeger
+	// baggage items, one per header.
+	JaegerBaggageHeaderPrefix = "uberctx-"
+
+	jaegerFlagSampled = 0x01
+	jaegerFlagDebug   = 0x02
+)
+
+var (
+	errInvalidJaegerHeader  = errors.New("invalid Jaeger header found")
+	errInvalidJaegerTraceID = errors.New("invalid Jaeger trace id found")
+	errInvalidJaegerSpanID  = errors.New("invalid Jaeger span id found")
+	errInvalidJaegerFlags   = errors.New("invalid Jaeger flags found")
+)
+
+// MarshalJaegerTraceContext uses the information in prop to create a Jaeger
+// uber-trace-id header, plus an uberctx- header for each string field in the
+// trace context. Fields are left out once the uberctx- headers reach 8192
+// bytes in total. The headers are returned as a map[string]string.
+//
+// Context is passed into this function and returned to match the other
+// propagation formats. If it carries the debug flag, as set by
+// UnmarshalJaegerTraceContext or UnmarshalB3TraceContext, the debug flag is
+// set in the header too.
+//
+// If prop is empty, nil or does not have valid trace and span IDs, the return
+// value will be an empty map.
+func MarshalJaegerTraceContext(ctx context.Context, prop *PropagationContext) (context.Context, map[string]string) {
+	headerMap := make(map[string]string)
+	if prop == nil {
+		return ctx, headerMap
+	}
+
+	traceID, err := traceIDFromHex(prop.TraceID)
+	if err != nil {
+		return ctx, headerMap
+	}
+	spanID, err := spanIDFromHex(prop.ParentID)
+	if err != nil {
+		return ctx, headerMap
+	}
+
+	var flags int
+	if prop.TraceFlags.IsSampled() {
+		flags |= jaegerFlagSampled
+	}
+	if debugFromContext(ctx) {
+		flags |= jaegerFlagDebug | jaegerFlagSampled
+	}
+	// the parent span id is deprecated and always sent as 0
+	headerMap[JaegerTraceHeader] = fmt.Sprintf("%s:%s:0:%x", traceID, spanID, flags)
+
+	// go in key order so that the same fields are dropped each time
+	keys := make([]string, 0, len(prop.TraceContext))
+	for k := range prop.TraceContext {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	size := 0
+	for _, k := range keys {
+		// Jaeger baggage values are strings; other values wouldn't come back
+		// as they were sent
+		v, ok := prop.TraceContext[k].(string)
+		if !ok || !isBaggageToken(k) {
+			continue
+		}
+		name, value := JaegerBaggageHeaderPrefix+k, url.QueryEscape(v)
+		if size+len(name)+len(value) > maxBaggageBytes {
+			continue
+		}
+		size += len(name) + len(value)
+		headerMap[name] = value
+	}
+	return ctx, headerMap
+}
+
+// UnmarshalJaegerTraceContext parses the information provided in the Jaeger
+// headers and creates a PropagationContext instance. Header names in headers
+// are expected to be lowercase. Both 64-bit and 128-bit trace IDs are
+// accepted, and shorter IDs are left padded with zeros. The header may be URL
+// encoded. Values of uberctx- headers are added to the TraceContext.
+//
+// Context is passed into this function and returned so that the debug flag
+// can be carried through to MarshalJaegerTraceContext or
+// MarshalB3TraceContext.
+//
+// If the headers do not contain a valid uber-trace-id header, an error will be
+// returned.
+func UnmarshalJaegerTraceContext(ctx context.Context, headers map[string]string) (context.Context, *PropagationContext, error) {
+	h := getHeaderValue(headers, JaegerTraceHeader)
+	if h == "" {
+		return ctx, nil, errors.New("cannot unmarshal empty header")
+	}
+	if strings.Contains(h, "%") {
+		unescaped, err := url.QueryUnescape(h)
+		if err != nil {
+			return ctx, nil, errInvalidJaegerHeader
+		}
+		h = unescaped
+	}
+
+	parts := strings.Split(strings.ToLower(h), ":")
+	if len(parts) != 4 {
+		return ctx, nil, errInvalidJaegerHeader
+	}
+
+	if len(parts[0]) == 0 || len(parts[0]) > traceID128BitsWidth {
+		return ctx, nil, errInvalidJaegerTraceID
+	}
+	traceID, err := traceIDFromHex(leftPad(parts[0], traceID128BitsWidth))
+	if err != nil {
+		return ctx, nil, errInvalidJaegerTraceID
+	}
+	if len(parts[1]) == 0 || len(parts[1]) > spanIDWidth {
+		return ctx, nil, errInvalidJaegerSpanID
+	}
+	spanID, err := spanIDFromHex(leftPad(parts[1], spanIDWidth))
+	if err != nil {
+		return ctx, nil, errInvalidJaegerSpanID
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return ctx, nil, errInvalidJaegerFlags
+	}
+
+	prop := &PropagationContext{
+		TraceID:  traceID.String(),
+		ParentID: spanID.String(),
+	}
+	if flags&jaegerFlagSampled != 0 {
+		prop.TraceFlags = FlagsSampled
+	}
+	if flags&jaegerFlagDebug != 0 {
+		// debug implies sampled
+		prop.TraceFlags = FlagsSampled
+		ctx = withDebug(ctx, true)
+	}
+
+	for k, v := range headers {
+		if !strings.HasPrefix(strings.ToLower(k), JaegerBaggageHeaderPrefix) {
+			continue
+		}
+		key := k[len(JaegerBaggageHeaderPrefix):]
+		if key == "" {
+			continue
+		}
+		if unescaped, err := url.QueryUnescape(v); err == nil {
+			v = unescaped
+		}
+		if prop.TraceContext == nil {
+			prop.TraceContext = make(map[string]interface{})
+		}
+		prop.TraceContext[key] = v
+	}
+
+	return ctx, prop, nil
+}
+
+// leftPad pads s with leading zeros up to width characters.
+func leftPad(s string, width int) string {
+	if len(s) >= width {
+		return s
+	}
+	return strings.Repeat("0", width-len(s)) + s
+}
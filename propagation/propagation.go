@@ -12,6 +12,13 @@ var GlobalConfig Config
 
 type Config struct {
 	PropagateDataset bool
+	// Propagator, if set, is used by the HTTP and gRPC wrappers to read and
+	// write trace context headers, instead of the Honeycomb header. Parser and
+	// propagation hooks passed to a wrapper take precedence over it.
+	Propagator Propagator
+	// TraceFields selects the trace level fields sent in the trace context
+	// of outgoing requests. By default every field is sent.
+	TraceFields TraceFieldPolicy
 }
 
 // getHeaderValue is a helper function that is guaranteed to return a string. Given a key, it
@@ -33,6 +40,7 @@ type PropagationContext struct {
 	TraceContext map[string]interface{}
 	TraceFlags   TraceFlags
 	TraceState   TraceState
+	Baggage      Baggage
 }
 
 // hasTraceID checks that the trace ID is valid.
@@ -3,6 +3,9 @@ package propagation
 import (
 	"context"
 	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -420,6 +423,316 @@ func TestW3CTraceContext(t *testing.T) {
 	assert.Error(t, err, "cannot unmarshal empty header")
 }
 
+func TestW3CBaggage(t *testing.T) {
+	headers := map[string]string{
+		"baggage": "tenant=acme , user%20name=J%C3%BCrgen;ephemeral;ttl=60,,empty=",
+	}
+	baggage, err := UnmarshalW3CBaggage(headers)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, baggage.Len())
+	assert.Equal(t, "acme", baggage.Get("tenant"))
+	assert.Equal(t, "", baggage.Get("empty"))
+	assert.Equal(t, "Jürgen", baggage.Get("user%20name"), "values are percent-decoded but keys are not")
+
+	baggage, err = ParseBaggage("user=J%C3%BCrgen;ephemeral;ttl=60")
+	assert.NoError(t, err)
+	m, ok := baggage.Member("user")
+	assert.True(t, ok)
+	assert.Equal(t, "Jürgen", m.Value)
+	assert.Equal(t, []BaggageProperty{{Key: "ephemeral"}, {Key: "ttl", Value: "60", HasValue: true}}, m.Properties)
+	assert.Equal(t, "user=J%C3%BCrgen;ephemeral;ttl=60", baggage.String())
+
+	// values are percent-encoded when set
+	baggage, err = baggage.Set("note", "100% a,b;c")
+	assert.NoError(t, err)
+	assert.Equal(t, "100% a,b;c", baggage.Get("note"))
+	prop := &PropagationContext{Baggage: baggage}
+	assert.Equal(t, "user=J%C3%BCrgen;ephemeral;ttl=60,note=100%25%20a%2Cb%3Bc", MarshalW3CBaggage(prop)["baggage"])
+
+	// the W3C trace context headers leave baggage out, but it is read along
+	// with them, and the W3C propagator carries it both ways
+	prop.TraceID = "0af7651916cd43dd8448eb211c80319c"
+	prop.ParentID = "b7ad6b7169203331"
+	ctx, headers := MarshalW3CTraceContext(context.Background(), prop)
+	assert.NotContains(t, headers, BaggageHeader)
+	headers[BaggageHeader] = MarshalW3CBaggage(prop)[BaggageHeader]
+	_, roundTripped, err := UnmarshalW3CTraceContext(ctx, headers)
+	assert.NoError(t, err)
+	assert.Equal(t, baggage, roundTripped.Baggage)
+	carrier := HeaderCarrier(http.Header{})
+	W3CPropagator{}.Inject(prop, carrier)
+	roundTripped, err = W3CPropagator{}.Extract(carrier)
+	assert.NoError(t, err)
+	assert.Equal(t, baggage, roundTripped.Baggage)
+
+	// invalid keys, values and encodings are rejected
+	for _, invalid := range []string{"no-value", "bad key=1", "k=a b", "k=%zz", "k=%C3", "k=1;bad prop"} {
+		_, err = ParseBaggage(invalid)
+		assert.Error(t, err, invalid)
+	}
+	_, err = baggage.Set("bad key", "v")
+	assert.Error(t, err)
+
+	// size limits
+	_, err = ParseBaggage("k=" + strings.Repeat("v", maxBaggageBytes))
+	assert.Error(t, err)
+	many := make([]string, maxBaggageMembers+1)
+	for i := range many {
+		many[i] = fmt.Sprintf("k%d=v", i)
+	}
+	_, err = ParseBaggage(strings.Join(many, ","))
+	assert.Error(t, err)
+	_, err = Baggage{}.Set("k", strings.Repeat("v", maxBaggageBytes))
+	assert.Error(t, err)
+
+	assert.Equal(t, 0, len(MarshalW3CBaggage(nil)))
+	assert.Equal(t, 0, len(MarshalW3CBaggage(&PropagationContext{})))
+}
+
+func TestJaegerTraceContext(t *testing.T) {
+	prop := &PropagationContext{
+		TraceID:      "0af7651916cd43dd8448eb211c80319c",
+		ParentID:     "b7ad6b7169203331",
+		TraceFlags:   FlagsSampled,
+		TraceContext: map[string]interface{}{"tenant": "a c&e", "bad key": "x"},
+	}
+	ctx, headers := MarshalJaegerTraceContext(context.Background(), prop)
+	assert.Equal(t, 2, len(headers))
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c:b7ad6b7169203331:0:1", headers["uber-trace-id"])
+	assert.Equal(t, "a+c%26e", headers["uberctx-tenant"])
+
+	_, roundTripped, err := UnmarshalJaegerTraceContext(ctx, headers)
+	assert.NoError(t, err)
+	assert.Equal(t, prop.TraceID, roundTripped.TraceID)
+	assert.Equal(t, prop.ParentID, roundTripped.ParentID)
+	assert.True(t, roundTripped.TraceFlags.IsSampled())
+	assert.Equal(t, map[string]interface{}{"tenant": "a c&e"}, roundTripped.TraceContext)
+
+	// only string fields are sent, up to a total size
+	prop.TraceContext = map[string]interface{}{
+		"count":  3,
+		"nested": map[string]interface{}{"a": "b"},
+		"a":      strings.Repeat("x", maxBaggageBytes/2),
+		"b":      strings.Repeat("y", maxBaggageBytes/2),
+		"c":      "small",
+	}
+	_, headers = MarshalJaegerTraceContext(context.Background(), prop)
+	assert.Contains(t, headers, "uberctx-a")
+	assert.NotContains(t, headers, "uberctx-b", "the field that doesn't fit is dropped")
+	assert.Equal(t, "small", headers["uberctx-c"])
+	assert.NotContains(t, headers, "uberctx-count")
+	assert.NotContains(t, headers, "uberctx-nested")
+
+	// 64-bit and zero-stripped ids, URL encoded header, debug flag
+	headers = map[string]string{
+		"uber-trace-id": "8448eb211c80319c%3Aad6b7169203331%3A0%3A3",
+	}
+	ctx, prop, err = UnmarshalJaegerTraceContext(context.Background(), headers)
+	assert.NoError(t, err)
+	assert.Equal(t, "00000000000000008448eb211c80319c", prop.TraceID)
+	assert.Equal(t, "00ad6b7169203331", prop.ParentID)
+	assert.True(t, prop.TraceFlags.IsSampled())
+	_, headers = MarshalJaegerTraceContext(ctx, prop)
+	assert.Equal(t, "00000000000000008448eb211c80319c:00ad6b7169203331:0:3", headers["uber-trace-id"],
+		"debug flag should be carried through")
+
+	// not sampled
+	_, prop, err = UnmarshalJaegerTraceContext(context.Background(), map[string]string{
+		"uber-trace-id": "0af7651916cd43dd8448eb211c80319c:b7ad6b7169203331:0:0",
+	})
+	assert.NoError(t, err)
+	assert.False(t, prop.TraceFlags.IsSampled())
+
+	for _, invalid := range []string{
+		"",
+		"0af7651916cd43dd8448eb211c80319c:b7ad6b7169203331:0",
+		"0:b7ad6b7169203331:0:1",
+		"0af7651916cd43dd8448eb211c80319c0:b7ad6b7169203331:0:1",
+		"0af7651916cd43dd8448eb211c80319c:xyz:0:1",
+		"0af7651916cd43dd8448eb211c80319c:b7ad6b7169203331:0:zz",
+	} {
+		_, _, err = UnmarshalJaegerTraceContext(context.Background(), map[string]string{"uber-trace-id": invalid})
+		assert.Error(t, err, invalid)
+	}
+
+	_, headers = MarshalJaegerTraceContext(context.Background(), &PropagationContext{TraceID: "abcdef", ParentID: "12345"})
+	assert.Equal(t, 0, len(headers), "non-hex ids cannot be sent as Jaeger headers")
+}
+
+func TestDatadogTraceContext(t *testing.T) {
+	prop := &PropagationContext{
+		TraceID:    "640cfd8d000000008448eb211c80319c",
+		ParentID:   "b7ad6b7169203331",
+		TraceFlags: FlagsSampled,
+	}
+	ctx, headers := MarshalDatadogTraceContext(context.Background(), prop)
+	assert.Equal(t, map[string]string{
+		"x-datadog-trace-id":          "9532127138774266268",
+		"x-datadog-parent-id":         "13235353014750950193",
+		"x-datadog-sampling-priority": "1",
+		"x-datadog-tags":              "_dd.p.tid=640cfd8d00000000",
+	}, headers)
+
+	_, roundTripped, err := UnmarshalDatadogTraceContext(ctx, headers)
+	assert.NoError(t, err)
+	assert.Equal(t, prop, roundTripped)
+
+	// 64-bit trace id, user reject, other tags
+	headers = map[string]string{
+		"x-datadog-trace-id":          "9532127138774266268",
+		"x-datadog-parent-id":         "1",
+		"x-datadog-sampling-priority": "-1",
+		"x-datadog-tags":              "_dd.p.dm=-4,_dd.p.tid=nothex",
+	}
+	_, prop, err = UnmarshalDatadogTraceContext(context.Background(), headers)
+	assert.NoError(t, err)
+	assert.Equal(t, "00000000000000008448eb211c80319c", prop.TraceID)
+	assert.Equal(t, "0000000000000001", prop.ParentID)
+	assert.False(t, prop.TraceFlags.IsSampled())
+	_, headers = MarshalDatadogTraceContext(context.Background(), prop)
+	assert.NotContains(t, headers, "x-datadog-tags")
+	assert.Equal(t, "0", headers["x-datadog-sampling-priority"])
+
+	// no sampling priority is passed along as no decision
+	delete(headers, "x-datadog-sampling-priority")
+	ctx, prop, err = UnmarshalDatadogTraceContext(context.Background(), headers)
+	assert.NoError(t, err)
+	_, headers = MarshalDatadogTraceContext(ctx, prop)
+	assert.NotContains(t, headers, "x-datadog-sampling-priority")
+
+	for _, invalid := range []map[string]string{
+		{},
+		{"x-datadog-trace-id": "abc", "x-datadog-parent-id": "1"},
+		{"x-datadog-trace-id": "1", "x-datadog-parent-id": "0"},
+		{"x-datadog-trace-id": "18446744073709551616", "x-datadog-parent-id": "1"},
+		{"x-datadog-trace-id": "1", "x-datadog-parent-id": "1", "x-datadog-sampling-priority": "keep"},
+	} {
+		_, _, err = UnmarshalDatadogTraceContext(context.Background(), invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	_, headers = MarshalDatadogTraceContext(context.Background(), &PropagationContext{TraceID: "abcdef", ParentID: "12345"})
+	assert.Equal(t, 0, len(headers), "non-hex ids cannot be sent as Datadog headers")
+}
+
+func TestCompositePropagator(t *testing.T) {
+	baggage, _ := ParseBaggage("tenant=acme")
+	prop := &PropagationContext{
+		TraceID:    "0af7651916cd43dd8448eb211c80319c",
+		ParentID:   "b7ad6b7169203331",
+		TraceFlags: FlagsSampled,
+		Baggage:    baggage,
+	}
+	composite := NewCompositePropagator(W3CPropagator{}, B3Propagator{}, HoneycombPropagator{})
+	header := HeaderCarrier(http.Header{})
+	composite.Inject(prop, header)
+	assert.Equal(t, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", http.Header(header).Get("traceparent"))
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", http.Header(header).Get("x-b3-traceid"))
+	assert.NotEmpty(t, http.Header(header).Get("X-Honeycomb-Trace"))
+	assert.Equal(t, "tenant=acme", http.Header(header).Get("baggage"))
+	assert.Empty(t, http.Header(header).Values("tracestate"), "empty tracestate should not be sent")
+
+	extracted, err := composite.Extract(header)
+	assert.NoError(t, err)
+	assert.Equal(t, prop.TraceID, extracted.TraceID)
+	assert.Equal(t, "acme", extracted.Baggage.Get("tenant"))
+
+	// extract priority: the first valid format wins
+	md := MetadataCarrier{}
+	B3Propagator{}.Inject(&PropagationContext{TraceID: "11111111111111111111111111111111", ParentID: "1111111111111111"}, md)
+	JaegerPropagator{}.Inject(&PropagationContext{TraceID: "22222222222222222222222222222222", ParentID: "2222222222222222"}, md)
+	md.Set("Baggage", "tenant=acme")
+	extracted, err = NewCompositePropagator(W3CPropagator{}, JaegerPropagator{}, B3Propagator{}).Extract(md)
+	assert.NoError(t, err)
+	assert.Equal(t, "22222222222222222222222222222222", extracted.TraceID)
+	assert.Equal(t, "acme", extracted.Baggage.Get("tenant"), "baggage should be read whichever format wins")
+	assert.Equal(t, []string{"tenant=acme"}, md["baggage"], "metadata keys should be lowercase")
+
+	// separate extract and inject lists
+	composite = &CompositePropagator{Extractors: []Propagator{AmazonPropagator{}}, Injectors: []Propagator{DatadogPropagator{}}}
+	extracted, err = composite.Extract(md)
+	assert.NoError(t, err)
+	assert.False(t, extracted.IsValid())
+	assert.Equal(t, "acme", extracted.Baggage.Get("tenant"), "baggage should be kept without a trace context")
+	_, err = composite.Extract(MetadataCarrier{})
+	assert.Error(t, err)
+	header = HeaderCarrier(http.Header{})
+	header.Set("X-Amzn-Trace-Id", "Root=1-67891233-abcdef012345678912345678;Parent=463ac35c9f6413ad")
+	extracted, err = composite.Extract(header)
+	assert.NoError(t, err)
+	assert.Equal(t, "463ac35c9f6413ad", extracted.ParentID)
+	composite.Inject(prop, header)
+	assert.Equal(t, "9532127138774266268", header.Get("x-datadog-trace-id"))
+}
+
+func TestCarriers(t *testing.T) {
+	prop := &PropagationContext{
+		TraceID:    "0af7651916cd43dd8448eb211c80319c",
+		ParentID:   "b7ad6b7169203331",
+		TraceFlags: FlagsSampled,
+	}
+	carriers := map[string]TextMapCarrier{
+		"header":   HeaderCarrier(http.Header{}),
+		"metadata": MetadataCarrier{},
+		"map":      MapCarrier{},
+		"bytes":    BytesMapCarrier{},
+	}
+	for name, carrier := range carriers {
+		t.Run(name, func(t *testing.T) {
+			NewCompositePropagator(W3CPropagator{}, HoneycombPropagator{}).Inject(prop, carrier)
+			assert.Len(t, carrier.Keys(), 2)
+			assert.Equal(t, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", carrier.Get("Traceparent"), "lookups should be case insensitive")
+
+			extracted, err := HoneycombPropagator{}.Extract(carrier)
+			assert.NoError(t, err)
+			assert.Equal(t, prop.TraceID, extracted.TraceID)
+			assert.Equal(t, prop.ParentID, extracted.ParentID)
+		})
+	}
+	assert.Equal(t, []byte("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"), carriers["bytes"].(BytesMapCarrier)["traceparent"])
+}
+
+func TestEnvTraceContext(t *testing.T) {
+	baggage, _ := ParseBaggage("tenant=acme")
+	traceState, _ := ParseTraceState("vendor=value")
+	prop := &PropagationContext{
+		TraceID:      "0af7651916cd43dd8448eb211c80319c",
+		ParentID:     "b7ad6b7169203331",
+		TraceFlags:   FlagsSampled,
+		TraceContext: map[string]interface{}{"userID": "1"},
+		TraceState:   traceState,
+		Baggage:      baggage,
+	}
+	env := MarshalEnvTraceContext(prop)
+	assert.Equal(t, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", env[TraceparentEnv])
+	assert.Equal(t, "vendor=value", env[TracestateEnv])
+	assert.Equal(t, "tenant=acme", env[BaggageEnv])
+	assert.Equal(t, MarshalHoneycombTraceContext(prop), env[HoneycombTraceEnv])
+	assert.Empty(t, MarshalEnvTraceContext(nil))
+
+	// the Honeycomb header is preferred, as it carries trace fields
+	unmarshalled, err := UnmarshalEnvTraceContext(env)
+	assert.NoError(t, err)
+	assert.Equal(t, prop.TraceID, unmarshalled.TraceID)
+	assert.Equal(t, prop.ParentID, unmarshalled.ParentID)
+	assert.Equal(t, "1", unmarshalled.TraceContext["userID"])
+	assert.Equal(t, "value", unmarshalled.TraceState.Get("vendor"))
+	assert.Equal(t, "acme", unmarshalled.Baggage.Get("tenant"))
+
+	// falls back to traceparent
+	env[HoneycombTraceEnv] = "1"
+	unmarshalled, err = UnmarshalEnvTraceContext(env)
+	assert.NoError(t, err)
+	assert.Equal(t, prop.TraceID, unmarshalled.TraceID)
+	assert.True(t, unmarshalled.TraceFlags.IsSampled())
+	assert.Equal(t, "acme", unmarshalled.Baggage.Get("tenant"))
+
+	_, err = UnmarshalEnvTraceContext(map[string]string{})
+	assert.Error(t, err)
+	_, err = UnmarshalEnvTraceContext(map[string]string{TraceparentEnv: "invalid"})
+	assert.Error(t, err)
+}
+
 func TestB3TraceContext(t *testing.T) {
 	prop := &PropagationContext{
 		TraceID:  "0af7651916cd43dd8448eb211c80319c",
@@ -485,6 +798,12 @@ func TestUnmarshalHoneycombTraceContext(t *testing.T) {
 			nil,
 			true,
 		},
+		{
+			"v1 without the version separator",
+			"1",
+			nil,
+			true,
+		},
 		{
 			"v1 trace_id + parent_id, missing context",
 			"1;trace_id=abcdef,parent_id=12345",
@@ -633,3 +952,56 @@ func TestUnmarshalAmazonTraceContext(t *testing.T) {
 		}
 	}
 }
+
+func TestTraceFieldPolicy(t *testing.T) {
+	newProp := func() *PropagationContext {
+		return &PropagationContext{
+			TraceID:  "abcdef",
+			ParentID: "12345",
+			TraceContext: map[string]interface{}{
+				"app.user_id":      1,
+				"app.tenant.id":    "acme",
+				"app.tenant.plan":  "enterprise",
+				"app.request_body": strings.Repeat("x", 200),
+			},
+		}
+	}
+
+	prop := newProp()
+	assert.False(t, TraceFieldPolicy{}.Apply(prop))
+	assert.Equal(t, newProp(), prop, "every field is sent by default")
+
+	prop = newProp()
+	policy := TraceFieldPolicy{Allow: []string{"app.user_id"}, Prefixes: []string{"app.tenant."}}
+	assert.False(t, policy.Apply(prop))
+	assert.Equal(t, map[string]interface{}{
+		"app.user_id":     1,
+		"app.tenant.id":   "acme",
+		"app.tenant.plan": "enterprise",
+	}, prop.TraceContext)
+
+	// fields are dropped largest first, then in reverse order of their names
+	full := len(MarshalHoneycombTraceContext(newProp()))
+	testCases := []struct {
+		maxBytes int
+		expected []string
+	}{
+		{full, []string{"app.request_body", "app.tenant.id", "app.tenant.plan", "app.user_id"}},
+		{full - 1, []string{"app.tenant.id", "app.tenant.plan", "app.user_id"}},
+		{100, []string{"app.tenant.id", "app.user_id"}},
+		{10, []string{}},
+	}
+	for _, tc := range testCases {
+		prop = newProp()
+		truncated := TraceFieldPolicy{MaxHeaderBytes: tc.maxBytes}.Apply(prop)
+		assert.Equal(t, len(tc.expected) < 4, truncated, "max %d", tc.maxBytes)
+		keys := []string{}
+		for k := range prop.TraceContext {
+			keys = append(keys, k)
+		}
+		assert.ElementsMatch(t, tc.expected, keys, "max %d", tc.maxBytes)
+		if len(tc.expected) > 0 {
+			assert.LessOrEqual(t, len(MarshalHoneycombTraceContext(prop)), tc.maxBytes)
+		}
+	}
+}
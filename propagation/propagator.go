@@ -0,0 +1,197 @@
+package propagation
+
+import (
+	"context"
+	"errors"
+)
+
+// Propagator reads and writes trace context headers in one or more formats.
+// Set one as GlobalConfig.Propagator, usually through beeline.Config, to have
+// every HTTP and gRPC wrapper use it instead of the Honeycomb header. Parser
+// and propagation hooks passed to a wrapper still take precedence.
+type Propagator interface {
+	// Extract reads a PropagationContext from the carrier. It returns an
+	// error if the carrier holds no valid trace context in this format.
+	Extract(carrier TextMapCarrier) (*PropagationContext, error)
+	// Inject writes prop to the carrier.
+	Inject(prop *PropagationContext, carrier TextMapCarrier)
+}
+
+// HoneycombPropagator propagates trace context in the Honeycomb header, plus
+// any baggage in the W3C baggage header.
+type HoneycombPropagator struct{}
+
+// Extract reads the Honeycomb header and the W3C baggage header.
+func (HoneycombPropagator) Extract(carrier TextMapCarrier) (*PropagationContext, error) {
+	prop, err := UnmarshalHoneycombTraceContext(carrier.Get(TracePropagationHTTPHeader))
+	if err != nil {
+		return nil, err
+	}
+	prop.Baggage, _ = ParseBaggage(carrier.Get(BaggageHeader))
+	return prop, nil
+}
+
+// Inject writes the Honeycomb header, and the W3C baggage header if prop has
+// baggage.
+func (HoneycombPropagator) Inject(prop *PropagationContext, carrier TextMapCarrier) {
+	if prop == nil {
+		return
+	}
+	carrier.Set(TracePropagationHTTPHeader, MarshalHoneycombTraceContext(prop))
+	setHeaders(carrier, MarshalW3CBaggage(prop))
+}
+
+// W3CPropagator propagates trace context in the W3C traceparent, tracestate
+// and baggage headers.
+type W3CPropagator struct{}
+
+// Extract reads the W3C headers.
+func (W3CPropagator) Extract(carrier TextMapCarrier) (*PropagationContext, error) {
+	_, prop, err := UnmarshalW3CTraceContext(context.Background(), carrierHeaders(carrier))
+	if err != nil {
+		return nil, err
+	}
+	return prop, nil
+}
+
+// Inject writes the W3C headers.
+func (W3CPropagator) Inject(prop *PropagationContext, carrier TextMapCarrier) {
+	if prop == nil {
+		return
+	}
+	_, headers := MarshalW3CTraceContext(context.Background(), prop)
+	if headers[TracestateHeader] == "" {
+		delete(headers, TracestateHeader)
+	}
+	setHeaders(carrier, headers)
+	setHeaders(carrier, MarshalW3CBaggage(prop))
+}
+
+// B3Propagator propagates trace context in the B3 headers. It reads both the
+// single and multiple header forms, and writes the multiple header form.
+type B3Propagator struct{}
+
+// Extract reads the B3 headers.
+func (B3Propagator) Extract(carrier TextMapCarrier) (*PropagationContext, error) {
+	_, prop, err := UnmarshalB3TraceContext(context.Background(), carrierHeaders(carrier))
+	if err != nil {
+		return nil, err
+	}
+	return prop, nil
+}
+
+// Inject writes the B3 headers.
+func (B3Propagator) Inject(prop *PropagationContext, carrier TextMapCarrier) {
+	if prop == nil {
+		return
+	}
+	_, headers := MarshalB3TraceContext(context.Background(), prop)
+	setHeaders(carrier, headers)
+}
+
+// AmazonPropagator propagates trace context in the X-Amzn-Trace-Id header.
+type AmazonPropagator struct{}
+
+// Extract reads the X-Amzn-Trace-Id header.
+func (AmazonPropagator) Extract(carrier TextMapCarrier) (*PropagationContext, error) {
+	h := carrier.Get(AmazonTraceHeader)
+	if h == "" {
+		return nil, errors.New("cannot unmarshal empty header")
+	}
+	return UnmarshalAmazonTraceContext(h)
+}
+
+// Inject writes the X-Amzn-Trace-Id header.
+func (AmazonPropagator) Inject(prop *PropagationContext, carrier TextMapCarrier) {
+	if prop == nil {
+		return
+	}
+	carrier.Set(AmazonTraceHeader, MarshalAmazonTraceContext(prop))
+}
+
+// JaegerPropagator propagates trace context in the Jaeger uber-trace-id and
+// uberctx- headers.
+type JaegerPropagator struct{}
+
+// Extract reads the Jaeger headers.
+func (JaegerPropagator) Extract(carrier TextMapCarrier) (*PropagationContext, error) {
+	_, prop, err := UnmarshalJaegerTraceContext(context.Background(), carrierHeaders(carrier))
+	if err != nil {
+		return nil, err
+	}
+	return prop, nil
+}
+
+// Inject writes the Jaeger headers.
+func (JaegerPropagator) Inject(prop *PropagationContext, carrier TextMapCarrier) {
+	_, headers := MarshalJaegerTraceContext(context.Background(), prop)
+	setHeaders(carrier, headers)
+}
+
+// DatadogPropagator propagates trace context in the Datadog x-datadog-*
+// headers.
+type DatadogPropagator struct{}
+
+// Extract reads the Datadog headers.
+func (DatadogPropagator) Extract(carrier TextMapCarrier) (*PropagationContext, error) {
+	_, prop, err := UnmarshalDatadogTraceContext(context.Background(), carrierHeaders(carrier))
+	if err != nil {
+		return nil, err
+	}
+	return prop, nil
+}
+
+// Inject writes the Datadog headers.
+func (DatadogPropagator) Inject(prop *PropagationContext, carrier TextMapCarrier) {
+	_, headers := MarshalDatadogTraceContext(context.Background(), prop)
+	setHeaders(carrier, headers)
+}
+
+// CompositePropagator combines several propagators, so that a service can
+// accept and send more than one header format, for example while migrating
+// from one format to another.
+type CompositePropagator struct {
+	// Extractors are tried in order, and the first one to find a valid trace
+	// context wins. If the winner did not read any baggage, or none of them
+	// finds a trace context, baggage is read from the W3C baggage header.
+	Extractors []Propagator
+	// Injectors all write their headers to the carrier.
+	Injectors []Propagator
+}
+
+// NewCompositePropagator returns a CompositePropagator that extracts with the
+// given propagators in priority order and injects with all of them.
+func NewCompositePropagator(propagators ...Propagator) *CompositePropagator {
+	return &CompositePropagator{
+		Extractors: propagators,
+		Injectors:  propagators,
+	}
+}
+
+// Extract returns the trace context found by the first extractor to find a
+// valid one. If none does, it returns a context holding only the baggage, or
+// an error if there is no baggage either.
+func (c *CompositePropagator) Extract(carrier TextMapCarrier) (*PropagationContext, error) {
+	for _, p := range c.Extractors {
+		prop, err := p.Extract(carrier)
+		if err != nil || prop == nil || !prop.IsValid() {
+			continue
+		}
+		if prop.Baggage.Len() == 0 {
+			prop.Baggage, _ = ParseBaggage(carrier.Get(BaggageHeader))
+		}
+		return prop, nil
+	}
+	// baggage is independent of the trace context
+	if baggage, _ := ParseBaggage(carrier.Get(BaggageHeader)); baggage.Len() > 0 {
+		return &PropagationContext{Baggage: baggage}, nil
+	}
+	return nil, errors.New("no valid trace context found")
+}
+
+// Inject writes the headers of every injector to the carrier.
+func (c *CompositePropagator) Inject(prop *PropagationContext, carrier TextMapCarrier) {
+	for _, p := range c.Injectors {
+		p.Inject(prop, carrier)
+	}
+}
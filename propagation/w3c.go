@@ -12,12 +12,12 @@ const (
 	supportedVersion  = 0
 	maxVersion        = 254
 	TraceparentHeader = "traceparent"
-	tracestateHeader  = "tracestate"
+	TracestateHeader  = "tracestate"
 )
 
 var traceCtxRegExp = regexp.MustCompile("^(?P<version>[0-9a-f]{2})-(?P<traceID>[a-f0-9]{32})-(?P<spanID>[a-f0-9]{16})-(?P<traceFlags>[a-f0-9]{2})(?:-.*)?$")
 
-// MarshalHoneycombTraceContext uses the information in prop to create trace context headers
+// MarshalW3CTraceContext uses the information in prop to create trace context headers
 // that conform to the W3C Trace Context specification. The header values are set in headers,
 // which is an HTTPSupplier, an interface to which http.Header is an implementation. The headers
 // are also returned as a map[string]string.
@@ -39,7 +39,7 @@ func MarshalW3CTraceContext(ctx context.Context, prop *PropagationContext) (cont
 		return ctx, headerMap
 	}
 
-	headerMap[tracestateHeader] = prop.TraceState.String()
+	headerMap[TracestateHeader] = prop.TraceState.String()
 
 	// Clear all flags other than the trace-context supported sampling bit.
 	flags := prop.TraceFlags & FlagsSampled
@@ -55,7 +55,7 @@ func MarshalW3CTraceContext(ctx context.Context, prop *PropagationContext) (cont
 }
 
 // UnmarshalW3CTraceContext parses the information provided in the appropriate headers
-// and creates a PropagationContext instance. Headers are passed in via an HTTPSupplier,
+// and creates a PropagationContext instance, including any W3C baggage. Headers are passed in via an HTTPSupplier,
 // which is an interface that defines Get and Set methods, http.Header is an implementation.
 //
 // Context is passed into this function and returned so that we can maintain the value of the
@@ -132,7 +132,10 @@ func UnmarshalW3CTraceContext(ctx context.Context, headers map[string]string) (c
 	// Ignore the error returned here. Failure to parse tracestate MUST NOT
 	// affect the parsing of traceparent according to the W3C tracecontext
 	// specification.
-	prop.TraceState, _ = ParseTraceState(getHeaderValue(headers, tracestateHeader))
+	prop.TraceState, _ = ParseTraceState(getHeaderValue(headers, TracestateHeader))
+	// Baggage is independent of the trace context, so invalid baggage is
+	// dropped without failing either.
+	prop.Baggage, _ = UnmarshalW3CBaggage(headers)
 
 	return ctx, prop, nil
 }
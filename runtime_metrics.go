@@ -0,0 +1,219 @@
+package beeline
+
+import (
+	"math"
+	"runtime/metrics"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/beeline-go/client"
+)
+
+// These are the runtime/metrics samples read by the runtime metrics collector.
+// The order matters; the collector indexes into its samples by these offsets.
+const (
+	rmGoroutines = iota
+	rmHeapObjects
+	rmHeapUnused
+	rmGCCycles
+	rmGCPauses
+	rmSchedLatencies
+	rmCPUTotal
+	rmCPUIdle
+	rmCPUUser
+	rmCPUGC
+)
+
+var runtimeMetricNames = []string{
+	rmGoroutines:     "/sched/goroutines:goroutines",
+	rmHeapObjects:    "/memory/classes/heap/objects:bytes",
+	rmHeapUnused:     "/memory/classes/heap/unused:bytes",
+	rmGCCycles:       "/gc/cycles/total:gc-cycles",
+	rmGCPauses:       "/sched/pauses/total/gc:seconds",
+	rmSchedLatencies: "/sched/latencies:seconds",
+	rmCPUTotal:       "/cpu/classes/total:cpu-seconds",
+	rmCPUIdle:        "/cpu/classes/idle:cpu-seconds",
+	rmCPUUser:        "/cpu/classes/user:cpu-seconds",
+	rmCPUGC:          "/cpu/classes/gc/total:cpu-seconds",
+}
+
+var (
+	runtimeMetricsLock sync.Mutex
+	runtimeMetrics     *runtimeMetricsCollector
+)
+
+// runtimeMetricsCollector periodically reads runtime/metrics and sends the
+// results to Honeycomb as a `meta.type=runtime_metrics` event. Histograms and
+// CPU time are reported as the change since the previous event.
+type runtimeMetricsCollector struct {
+	interval time.Duration
+	samples  []metrics.Sample
+
+	prevCounts map[int][]uint64
+	prevValues map[int]float64
+	prevGC     uint64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startRuntimeMetrics replaces any running collector with a new one sending at
+// the given interval. A non-positive interval just stops the running one.
+func startRuntimeMetrics(interval time.Duration) {
+	runtimeMetricsLock.Lock()
+	defer runtimeMetricsLock.Unlock()
+	if runtimeMetrics != nil {
+		runtimeMetrics.close()
+		runtimeMetrics = nil
+	}
+	if interval <= 0 {
+		return
+	}
+	c := &runtimeMetricsCollector{
+		interval:   interval,
+		samples:    make([]metrics.Sample, len(runtimeMetricNames)),
+		prevCounts: make(map[int][]uint64),
+		prevValues: make(map[int]float64),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	for i, name := range runtimeMetricNames {
+		c.samples[i].Name = name
+	}
+	// prime the previous values so the first event reports a real interval
+	c.read()
+	runtimeMetrics = c
+	go c.run()
+}
+
+// stopRuntimeMetrics stops the running collector, if any, and waits for it to
+// exit.
+func stopRuntimeMetrics() {
+	startRuntimeMetrics(0)
+}
+
+func (c *runtimeMetricsCollector) run() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.send(c.read())
+		}
+	}
+}
+
+func (c *runtimeMetricsCollector) close() {
+	close(c.stop)
+	<-c.done
+}
+
+// read samples the runtime and returns the fields for an event, updating the
+// previous values used to compute deltas.
+func (c *runtimeMetricsCollector) read() map[string]interface{} {
+	metrics.Read(c.samples)
+	fields := make(map[string]interface{})
+
+	if v, ok := c.uint64Value(rmGoroutines); ok {
+		fields["runtime.goroutines"] = v
+	}
+	heapObjects, ok1 := c.uint64Value(rmHeapObjects)
+	heapUnused, ok2 := c.uint64Value(rmHeapUnused)
+	if ok1 && ok2 {
+		fields["runtime.heap_inuse_bytes"] = heapObjects + heapUnused
+	}
+	if v, ok := c.uint64Value(rmGCCycles); ok {
+		fields["runtime.gc_count"] = v
+		fields["runtime.gc_count_delta"] = v - c.prevGC
+		c.prevGC = v
+	}
+
+	c.addHistogramFields(fields, rmGCPauses, "runtime.gc_pause")
+	c.addHistogramFields(fields, rmSchedLatencies, "runtime.sched_latency")
+
+	total := c.float64Delta(rmCPUTotal)
+	idle := c.float64Delta(rmCPUIdle)
+	user := c.float64Delta(rmCPUUser)
+	gc := c.float64Delta(rmCPUGC)
+	fields["runtime.cpu_user_seconds"] = user
+	fields["runtime.cpu_gc_seconds"] = gc
+	if total > 0 {
+		fields["runtime.cpu_utilization"] = (total - idle) / total
+	}
+	return fields
+}
+
+func (c *runtimeMetricsCollector) send(fields map[string]interface{}) {
+	ev := client.NewBuilder().NewEvent()
+	ev.AddField("meta.type", "runtime_metrics")
+	ev.AddField("name", "runtime_metrics")
+	ev.AddFields(fields)
+	ev.Send()
+}
+
+func (c *runtimeMetricsCollector) uint64Value(i int) (uint64, bool) {
+	if c.samples[i].Value.Kind() != metrics.KindUint64 {
+		return 0, false
+	}
+	return c.samples[i].Value.Uint64(), true
+}
+
+// float64Delta returns the change in a cumulative float64 metric since the
+// previous read.
+func (c *runtimeMetricsCollector) float64Delta(i int) float64 {
+	if c.samples[i].Value.Kind() != metrics.KindFloat64 {
+		return 0
+	}
+	v := c.samples[i].Value.Float64()
+	delta := v - c.prevValues[i]
+	c.prevValues[i] = v
+	return delta
+}
+
+// addHistogramFields adds the p50, p90, p99 and max of the observations added
+// to a histogram metric since the previous read, in milliseconds.
+func (c *runtimeMetricsCollector) addHistogramFields(fields map[string]interface{}, i int, prefix string) {
+	if c.samples[i].Value.Kind() != metrics.KindFloat64Histogram {
+		return
+	}
+	h := c.samples[i].Value.Float64Histogram()
+	prev := c.prevCounts[i]
+	delta := make([]uint64, len(h.Counts))
+	var total uint64
+	for n, count := range h.Counts {
+		if n < len(prev) {
+			count -= prev[n]
+		}
+		delta[n] = count
+		total += count
+	}
+	// the runtime may reuse the histogram, so keep our own copy of the counts
+	c.prevCounts[i] = append(prev[:0], h.Counts...)
+	if total == 0 {
+		return
+	}
+	fields[prefix+"_p50_ms"] = histogramQuantile(delta, h.Buckets, total, 0.5) * 1000
+	fields[prefix+"_p90_ms"] = histogramQuantile(delta, h.Buckets, total, 0.9) * 1000
+	fields[prefix+"_p99_ms"] = histogramQuantile(delta, h.Buckets, total, 0.99) * 1000
+	fields[prefix+"_max_ms"] = histogramQuantile(delta, h.Buckets, total, 1) * 1000
+}
+
+// histogramQuantile returns the upper bound of the bucket containing the q-th
+// quantile of counts. If that bucket is unbounded, its lower bound is used.
+func histogramQuantile(counts []uint64, buckets []float64, total uint64, q float64) float64 {
+	threshold := uint64(math.Ceil(q * float64(total)))
+	var seen uint64
+	for n, count := range counts {
+		seen += count
+		if count > 0 && seen >= threshold {
+			if math.IsInf(buckets[n+1], 1) {
+				return buckets[n]
+			}
+			return buckets[n+1]
+		}
+	}
+	return buckets[len(buckets)-1]
+}
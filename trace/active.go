@@ -0,0 +1,115 @@
+package trace
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"weak"
+)
+
+// activeTraces holds a weak reference to every trace whose root span has not
+// yet been sent, so that they can be force-sent when the process shuts down.
+// The references are weak so that traces which are dropped without ever being
+// sent can still be garbage collected; a cleanup removes their entry.
+var activeTraces = struct {
+	sync.Mutex
+	traces map[weak.Pointer[Trace]]struct{}
+}{
+	traces: make(map[weak.Pointer[Trace]]struct{}),
+}
+
+// trackTrace registers a newly created trace as active.
+func trackTrace(t *Trace) {
+	wp := weak.Make(t)
+	activeTraces.Lock()
+	activeTraces.traces[wp] = struct{}{}
+	activeTraces.Unlock()
+	runtime.AddCleanup(t, forgetTrace, wp)
+}
+
+// untrackTrace removes a trace from the active set once its root span has been
+// sent.
+func untrackTrace(t *Trace) {
+	forgetTrace(weak.Make(t))
+}
+
+func forgetTrace(wp weak.Pointer[Trace]) {
+	activeTraces.Lock()
+	delete(activeTraces.traces, wp)
+	activeTraces.Unlock()
+}
+
+// getActiveTraces returns a snapshot of all traces that are still in flight.
+func getActiveTraces() []*Trace {
+	activeTraces.Lock()
+	defer activeTraces.Unlock()
+	traces := make([]*Trace, 0, len(activeTraces.traces))
+	for wp := range activeTraces.traces {
+		if t := wp.Value(); t != nil {
+			traces = append(traces, t)
+		}
+	}
+	return traces
+}
+
+// SendActiveTraces sends every trace in this process whose root span has not
+// yet been sent. All unsent spans in those traces, including asynchronous
+// ones, are sent with the field `meta.sent_by_shutdown` set. It is intended to
+// be called while shutting down; see `beeline.Shutdown`.
+//
+// If ctx is done before all traces have been sent, the remaining traces are
+// left alone. SendActiveTraces returns the number of spans it sent and the
+// number of spans it abandoned.
+func SendActiveTraces(ctx context.Context) (sent int, abandoned int) {
+	for _, t := range getActiveTraces() {
+		if ctx.Err() != nil {
+			abandoned += t.rootSpan.countUnsent()
+			continue
+		}
+		sent += t.rootSpan.sendByShutdown()
+	}
+	return sent, abandoned
+}
+
+// getChildrenCopy returns a copy of the span's children that is safe to
+// iterate over while the children are being sent.
+func (s *Span) getChildrenCopy() []*Span {
+	s.childrenLock.Lock()
+	defer s.childrenLock.Unlock()
+	children := make([]*Span, len(s.children))
+	copy(children, s.children)
+	return children
+}
+
+// sendByShutdown sends this span and all of its unsent descendants, children
+// first, and returns how many spans were sent.
+func (s *Span) sendByShutdown() int {
+	var count int
+	for _, child := range s.getChildrenCopy() {
+		count += child.sendByShutdown()
+	}
+
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	if s.isSent || s.ev == nil {
+		return count
+	}
+	s.AddField("meta.sent_by_shutdown", true)
+	s.sendLocked()
+	return count + 1
+}
+
+// countUnsent returns the number of spans in this span's subtree that have not
+// been sent.
+func (s *Span) countUnsent() int {
+	var count int
+	for _, child := range s.getChildrenCopy() {
+		count += child.countUnsent()
+	}
+	s.sendLock.RLock()
+	defer s.sendLock.RUnlock()
+	if !s.isSent && s.ev != nil {
+		count++
+	}
+	return count
+}
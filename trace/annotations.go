@@ -0,0 +1,70 @@
+package trace
+
+import (
+	"time"
+)
+
+// AddSpanEvent records a timestamped event that happened during this span,
+// such as a log line or a retry. Span events are sent along with the span as
+// separate events with a `meta.annotation_type` of `span_event`, are kept or
+// dropped along with the span, and share its sample rate.
+//
+// Errors in the fields map are converted to their message string, matching
+// AddField. The map is not modified.
+func (s *Span) AddSpanEvent(name string, fields map[string]interface{}) {
+	s.addAnnotation("span_event", name, fields)
+}
+
+// AddLink records that this span is related to another span, possibly in
+// another trace, that is neither its parent nor its child, such as the span
+// that enqueued a message this span is processing. Links are sent along with
+// the span as separate events with a `meta.annotation_type` of `link` and
+// `trace.link.trace_id`/`trace.link.span_id` fields pointing at the other
+// span, and are kept or dropped along with the span.
+func (s *Span) AddLink(traceID, spanID string, fields map[string]interface{}) {
+	linkFields := make(map[string]interface{}, len(fields)+2)
+	for k, v := range fields {
+		linkFields[k] = v
+	}
+	linkFields["trace.link.trace_id"] = traceID
+	linkFields["trace.link.span_id"] = spanID
+	s.addAnnotation("link", "", linkFields)
+}
+
+// addAnnotation queues an event that is sent after this span, pointing at it
+// as its parent.
+func (s *Span) addAnnotation(annotationType, name string, fields map[string]interface{}) {
+	s.eventLock.Lock()
+	defer s.eventLock.Unlock()
+	if s.ev == nil || s.trace == nil {
+		return
+	}
+	ev := s.trace.builder.NewEvent()
+	ev.Timestamp = time.Now()
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		ev.AddField(k, v)
+	}
+	ev.AddField("meta.annotation_type", annotationType)
+	if name != "" {
+		ev.AddField("name", name)
+	}
+	s.annotations = append(s.annotations, ev)
+}
+
+// sendAnnotationsLocked sends the annotations queued on this span once the
+// span itself has been sent. The caller must hold eventLock.
+func (s *Span) sendAnnotationsLocked() {
+	for _, ev := range s.annotations {
+		ev.AddField("trace.trace_id", s.trace.traceID)
+		ev.AddField("trace.parent_id", s.spanID)
+		ev.SampleRate = s.ev.SampleRate
+		if GlobalConfig.PresendHook != nil {
+			GlobalConfig.PresendHook(ev.Fields())
+		}
+		ev.SendPresampled()
+	}
+	s.annotations = nil
+}
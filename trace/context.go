@@ -4,12 +4,16 @@ import (
 	"context"
 	"errors"
 	"runtime/pprof"
+
+	"github.com/honeycombio/beeline-go/propagation"
 )
 
 const (
-	honeySpanContextKey  = "honeycombSpanContextKey"
-	honeyTraceContextKey = "honeycombTraceContextKey"
-	profileIDLabelName   = "span_id"
+	honeySpanContextKey        = "honeycombSpanContextKey"
+	honeyTraceContextKey       = "honeycombTraceContextKey"
+	honeyPropagationContextKey = "honeycombPropagationContextKey"
+	profileIDLabelName         = "span_id"
+	traceIDLabelName           = "trace_id"
 )
 
 var (
@@ -68,6 +72,45 @@ func PutSpanInContext(ctx context.Context, span *Span) context.Context {
 	return context.WithValue(ctx, honeySpanContextKey, span)
 }
 
+// GetPropagationContextFromContext retrieves the propagation context put in
+// the passed in context by PutPropagationContextInContext, or returns nil if
+// there is none.
+func GetPropagationContextFromContext(ctx context.Context) *propagation.PropagationContext {
+	if ctx != nil {
+		if val := ctx.Value(honeyPropagationContextKey); val != nil {
+			if prop, ok := val.(*propagation.PropagationContext); ok {
+				return prop
+			}
+		}
+	}
+	return nil
+}
+
+// GetOutgoingPropagationContextFromContext returns a copy of the propagation
+// context put in the passed in context by PutPropagationContextInContext, for
+// sending to downstream services, with only the trace level fields that
+// propagation.GlobalConfig.TraceFields allows. It also reports whether any
+// fields were dropped to fit its size limit. It returns nil if there is no
+// propagation context.
+func GetOutgoingPropagationContextFromContext(ctx context.Context) (*propagation.PropagationContext, bool) {
+	prop := GetPropagationContextFromContext(ctx)
+	if prop == nil {
+		return nil, false
+	}
+	out := *prop
+	truncated := propagation.GlobalConfig.TraceFields.Apply(&out)
+	return &out, truncated
+}
+
+// PutPropagationContextInContext takes an existing context and the
+// propagation context of an upstream trace, and pushes it into the context.
+// It is used when a request isn't traced, such as one dropped by a wrapper's
+// filter, so that the trace can still be passed on to downstream services,
+// and so that spans started without a span in the context continue it.
+func PutPropagationContextInContext(ctx context.Context, prop *propagation.PropagationContext) context.Context {
+	return context.WithValue(ctx, honeyPropagationContextKey, prop)
+}
+
 // CopyContext takes a context that has a beeline trace and one that doesn't. It
 // copies all the bits necessary to continue the trace from one to the other.
 // This is useful if you need to break context to launch a goroutine that
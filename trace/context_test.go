@@ -4,6 +4,7 @@ import (
 	"context"
 	"testing"
 
+	"github.com/honeycombio/beeline-go/propagation"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -28,6 +29,14 @@ func TestSpanFromContext(t *testing.T) {
 	assert.Equal(t, emptySpan, spanInCtx, "span in context should be span we put in the context")
 }
 
+func TestPropagationContextFromContext(t *testing.T) {
+	assert.Nil(t, GetPropagationContextFromContext(context.Background()))
+	prop := &propagation.PropagationContext{TraceID: "abcdef", ParentID: "0102"}
+	ctx := PutPropagationContextInContext(context.Background(), prop)
+	assert.Equal(t, prop, GetPropagationContextFromContext(ctx))
+	assert.Nil(t, GetSpanFromContext(ctx), "a propagation context is not a span")
+}
+
 func TestCopyContext(t *testing.T) {
 	ctx, tr := NewTrace(context.Background(), nil)
 	rs := tr.GetRootSpan()
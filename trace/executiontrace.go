@@ -0,0 +1,154 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	rtrace "runtime/trace"
+	"sync/atomic"
+	"time"
+)
+
+// spanTaskType is the runtime/trace task type used for every span. Task types
+// should be a small set, and the span name is usually not known when the span
+// is created, so the name is logged on the task once it is set instead.
+const spanTaskType = "beeline.span"
+
+// ProfileExecutionTrace is the kind of the execution traces dumped from the
+// flight recorder for slow spans.
+const ProfileExecutionTrace ProfileKind = "trace"
+
+// startTask opens a runtime/trace task for the span if execution tracing is
+// enabled, and returns the context carrying the task.
+func (s *Span) startTask(ctx context.Context) context.Context {
+	if !GlobalConfig.ExecutionTracing {
+		return ctx
+	}
+	ctx, s.task = rtrace.NewTask(ctx, spanTaskType)
+	s.taskCtx = ctx
+	rtrace.Log(ctx, "trace.trace_id", s.trace.traceID)
+	rtrace.Log(ctx, "trace.span_id", s.spanID)
+	return ctx
+}
+
+// logTaskName records the span's name on its runtime/trace task.
+func (s *Span) logTaskName(name interface{}) {
+	if s.task != nil {
+		rtrace.Log(s.taskCtx, "name", fmt.Sprint(name))
+	}
+}
+
+// endTask closes the span's runtime/trace task, if it has one.
+func (s *Span) endTask() {
+	if s.task != nil {
+		s.task.End()
+	}
+}
+
+// FlightRecording configures a runtime/trace flight recorder that keeps the
+// most recent execution trace in memory and dumps it when a span finishes
+// slower than a threshold. The dumped trace covers the slow span and can be
+// opened with `go tool trace`; with ExecutionTracing enabled, the span's task
+// can be found in it by its trace and span IDs. The span gets `trace_dump.id`
+// and, once written to Dir, `trace_dump.path` fields pointing at the dump.
+// Writing the dump holds up sending the slow span.
+//
+// The flight recorder requires Go 1.25 or later.
+type FlightRecording struct {
+	// SlowSpanThreshold is the duration above which a finished span triggers a
+	// dump of the execution trace.
+	SlowSpanThreshold time.Duration
+	// MinAge and MaxBytes bound the window of execution trace kept in memory.
+	// See runtime/trace.FlightRecorderConfig. default: chosen by the runtime
+	MinAge   time.Duration
+	MaxBytes uint64
+	// MinInterval is the minimum time between two dumps. default: 1m
+	MinInterval time.Duration
+	// Dir, if set, is the directory execution traces are written to.
+	Dir string
+	// Handler, if set, is called with each dumped execution trace.
+	Handler func(*Profile)
+}
+
+// flightRecorder is the part of runtime/trace.FlightRecorder used here.
+type flightRecorder interface {
+	WriteTo(w io.Writer) (int64, error)
+	Stop()
+}
+
+type flightRecorderState struct {
+	cfg      *FlightRecording
+	recorder flightRecorder
+	lastDump atomic.Int64
+}
+
+var activeFlightRecorder atomic.Pointer[flightRecorderState]
+
+// StartFlightRecorder starts a flight recorder that dumps the execution trace
+// whenever a span finishes slower than cfg.SlowSpanThreshold. Any flight
+// recorder started earlier is stopped first. It returns an error if the flight
+// recorder could not be started, for example on Go versions before 1.25.
+func StartFlightRecorder(cfg *FlightRecording) error {
+	StopFlightRecorder()
+	recorder, err := startRuntimeFlightRecorder(cfg)
+	if err != nil {
+		return err
+	}
+	activeFlightRecorder.Store(&flightRecorderState{cfg: cfg, recorder: recorder})
+	return nil
+}
+
+// StopFlightRecorder stops the flight recorder started by StartFlightRecorder,
+// if any.
+func StopFlightRecorder() {
+	if state := activeFlightRecorder.Swap(nil); state != nil {
+		state.recorder.Stop()
+	}
+}
+
+// checkFlightRecorder dumps the execution trace if the flight recorder is
+// running and this span took longer than its threshold. It must be called
+// before the span's event is sent so that the dump fields make it in.
+func (s *Span) checkFlightRecorder(duration time.Duration) {
+	state := activeFlightRecorder.Load()
+	if state == nil || duration < state.cfg.SlowSpanThreshold {
+		return
+	}
+	minInterval := state.cfg.MinInterval
+	if minInterval == 0 {
+		minInterval = defaultProfileMinInterval
+	}
+	release, ok := reserveSlot(&state.lastDump, minInterval)
+	if !ok {
+		return
+	}
+
+	// the dump is taken before the span is sent so that the fields pointing
+	// at it are only added once it exists
+	var buf bytes.Buffer
+	if _, err := state.recorder.WriteTo(&buf); err != nil {
+		release()
+		return
+	}
+	p := &Profile{
+		ID:      getNewID(spanIDLengthBytes),
+		TraceID: s.trace.traceID,
+		SpanID:  s.spanID,
+		Kind:    ProfileExecutionTrace,
+		Data:    buf.Bytes(),
+	}
+	s.AddField("trace_dump.id", p.ID)
+	if state.cfg.Dir != "" {
+		path := filepath.Join(state.cfg.Dir, fmt.Sprintf("%s-%s-%s.out", p.TraceID, p.SpanID, p.Kind))
+		if err := os.WriteFile(path, p.Data, 0o600); err == nil {
+			p.Path = path
+			s.AddField("trace_dump.path", p.Path)
+		}
+	}
+	if state.cfg.Handler != nil {
+		go state.cfg.Handler(p)
+	}
+}
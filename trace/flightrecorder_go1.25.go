@@ -0,0 +1,18 @@
+//go:build go1.25
+
+package trace
+
+import (
+	rtrace "runtime/trace"
+)
+
+func startRuntimeFlightRecorder(cfg *FlightRecording) (flightRecorder, error) {
+	fr := rtrace.NewFlightRecorder(rtrace.FlightRecorderConfig{
+		MinAge:   cfg.MinAge,
+		MaxBytes: cfg.MaxBytes,
+	})
+	if err := fr.Start(); err != nil {
+		return nil, err
+	}
+	return fr, nil
+}
@@ -0,0 +1,11 @@
+//go:build !go1.25
+
+package trace
+
+import (
+	"errors"
+)
+
+func startRuntimeFlightRecorder(cfg *FlightRecording) (flightRecorder, error) {
+	return nil, errors.New("the flight recorder requires Go 1.25 or later")
+}
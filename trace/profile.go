@@ -0,0 +1,230 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"sync/atomic"
+	"time"
+)
+
+// ProfileKind identifies what kind of profile is captured for a slow span.
+type ProfileKind string
+
+const (
+	// ProfileCPU captures a CPU profile for a short period while the span is
+	// still running.
+	ProfileCPU ProfileKind = "cpu"
+	// ProfileGoroutine captures a dump of all goroutine stacks.
+	ProfileGoroutine ProfileKind = "goroutine"
+)
+
+const (
+	defaultProfileCPUDuration = time.Second
+	defaultProfileMinInterval = time.Minute
+)
+
+// lastProfileTime is the time the last slow span profile was started, in unix
+// nanoseconds. It is shared by all spans to rate limit profiling globally.
+var lastProfileTime atomic.Int64
+
+// SlowSpanProfiling configures automatic profiling of slow spans. When a span
+// has been running for longer than Threshold, a profile is captured while the
+// span is still in progress. The span gets a `profile.id` field, plus
+// `profile.path` once the profile has been written to Dir, so the profile of
+// the actual slow request can be found from the trace. A CPU profile that
+// outlasts its span leaves the span without a path.
+//
+// The profile is captured with its trace and span IDs as pprof labels. Set
+// PprofTagging as well so that CPU samples taken on a root span's goroutine
+// carry its span ID as a pprof label too.
+type SlowSpanProfiling struct {
+	// Threshold is how long a span must run before it is profiled. Profiling
+	// is disabled if it is not positive.
+	Threshold time.Duration
+	// Predicate, if set, is called with the span's fields once Threshold is
+	// reached. The span is only profiled if it returns true. The map must not
+	// be modified.
+	Predicate func(fields map[string]interface{}) bool
+	// Kind is the kind of profile to capture. default: ProfileCPU
+	Kind ProfileKind
+	// CPUDuration is how long a CPU profile runs for. default: 1s
+	CPUDuration time.Duration
+	// MinInterval is the minimum time between two profiles, across all spans.
+	// default: 1m
+	MinInterval time.Duration
+	// Dir, if set, is the directory profiles are written to. Files are named
+	// after the trace ID, span ID and profile kind.
+	Dir string
+	// Handler, if set, is called with each captured profile. It is called
+	// after the profile has been written to Dir, if Dir is set.
+	Handler func(*Profile)
+}
+
+// Profile is a profile captured for a slow span.
+type Profile struct {
+	// ID is the unique ID of this profile, also set as `profile.id` on the span.
+	ID string
+	// TraceID and SpanID identify the span that was profiled.
+	TraceID string
+	SpanID  string
+	// Kind is the kind of profile.
+	Kind ProfileKind
+	// Path is the file the profile was written to, if any.
+	Path string
+	// Data is the profile in pprof format.
+	Data []byte
+}
+
+// startProfileTimer arms a timer that profiles this span if it runs for longer
+// than the configured threshold.
+func (s *Span) startProfileTimer() {
+	cfg := GlobalConfig.SlowSpanProfiling
+	if cfg == nil || cfg.Threshold <= 0 {
+		return
+	}
+	s.profileTimer = time.AfterFunc(cfg.Threshold, func() {
+		s.profile(cfg)
+	})
+}
+
+// profile captures a profile for this span if it is still running, matches the
+// configured predicate, and the global rate limit allows it.
+func (s *Span) profile(cfg *SlowSpanProfiling) {
+	s.sendLock.RLock()
+	sent := s.isSent
+	s.sendLock.RUnlock()
+	if sent || s.ev == nil {
+		return
+	}
+	if cfg.Predicate != nil {
+		s.eventLock.Lock()
+		keep := cfg.Predicate(s.ev.Fields())
+		s.eventLock.Unlock()
+		if !keep {
+			return
+		}
+	}
+	minInterval := cfg.MinInterval
+	if minInterval == 0 {
+		minInterval = defaultProfileMinInterval
+	}
+	release, ok := reserveSlot(&lastProfileTime, minInterval)
+	if !ok {
+		return
+	}
+
+	kind := cfg.Kind
+	if kind == "" {
+		kind = ProfileCPU
+	}
+	p := &Profile{
+		ID:      getNewID(spanIDLengthBytes),
+		TraceID: s.trace.traceID,
+		SpanID:  s.spanID,
+		Kind:    kind,
+	}
+	// label the capture so its own samples can be told apart in the profile
+	labels := pprof.Labels(traceIDLabelName, p.TraceID, profileIDLabelName, p.SpanID)
+	pprof.Do(context.Background(), labels, func(context.Context) {
+		s.captureProfile(cfg, p, release)
+	})
+}
+
+// captureProfile captures p for this span, writes it to cfg.Dir and hands it
+// to cfg.Handler. release gives back the rate limit slot if no profile could
+// be started.
+func (s *Span) captureProfile(cfg *SlowSpanProfiling, p *Profile, release func()) {
+	var buf bytes.Buffer
+	switch p.Kind {
+	case ProfileGoroutine:
+		if err := pprof.Lookup("goroutine").WriteTo(&buf, 0); err != nil {
+			release()
+			return
+		}
+	default:
+		// this fails if another CPU profile is already running
+		if err := pprof.StartCPUProfile(&buf); err != nil {
+			release()
+			return
+		}
+		// add the fields now that the profile is underway; a CPU profile
+		// may outlast the span.
+		if !s.addProfileFields(p) {
+			// the span was sent in the meantime, so nothing would lead to
+			// the profile
+			pprof.StopCPUProfile()
+			return
+		}
+		duration := cfg.CPUDuration
+		if duration <= 0 {
+			duration = defaultProfileCPUDuration
+		}
+		time.Sleep(duration)
+		pprof.StopCPUProfile()
+	}
+	p.Data = buf.Bytes()
+
+	if cfg.Dir != "" {
+		path := filepath.Join(cfg.Dir, fmt.Sprintf("%s-%s-%s.pprof", p.TraceID, p.SpanID, p.Kind))
+		if err := os.WriteFile(path, p.Data, 0o600); err == nil {
+			p.Path = path
+		}
+	}
+	if p.Kind == ProfileGoroutine {
+		if !s.addProfileFields(p) {
+			return
+		}
+	} else if p.Path != "" {
+		// the span may have finished while the CPU profile ran, in which
+		// case it goes without the path
+		s.addFieldIfRunning("profile.path", p.Path)
+	}
+	if cfg.Handler != nil {
+		cfg.Handler(p)
+	}
+}
+
+// addProfileFields adds the fields identifying p to this span, and reports
+// whether it did. It doesn't if the span has already been sent.
+func (s *Span) addProfileFields(p *Profile) bool {
+	s.sendLock.RLock()
+	defer s.sendLock.RUnlock()
+	if s.isSent {
+		return false
+	}
+	s.AddField("profile.id", p.ID)
+	s.AddField("profile.kind", string(p.Kind))
+	if p.Path != "" {
+		s.AddField("profile.path", p.Path)
+	}
+	return true
+}
+
+// addFieldIfRunning adds a field to this span unless it has already been sent.
+func (s *Span) addFieldIfRunning(key string, val interface{}) {
+	s.sendLock.RLock()
+	defer s.sendLock.RUnlock()
+	if !s.isSent {
+		s.AddField(key, val)
+	}
+}
+
+// reserveSlot reports whether a profile may be started now, given the time of
+// the last one and the minimum interval between them, and records the start if
+// so. The returned func gives the slot back, for when the profile could not be
+// taken after all.
+func reserveSlot(lastTime *atomic.Int64, minInterval time.Duration) (release func(), ok bool) {
+	now := time.Now().UnixNano()
+	last := lastTime.Load()
+	if last != 0 && now-last < int64(minInterval) {
+		return nil, false
+	}
+	if !lastTime.CompareAndSwap(last, now) {
+		return nil, false
+	}
+	return func() { lastTime.CompareAndSwap(now, last) }, true
+}
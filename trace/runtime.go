@@ -0,0 +1,97 @@
+package trace
+
+import (
+	"math"
+	"runtime/metrics"
+)
+
+// runtimeDeltaMetrics are the runtime/metrics samples taken when a root span
+// starts and again when it is sent, when Config.RuntimeDeltas is enabled.
+var runtimeDeltaMetrics = []string{
+	"/gc/cycles/total:gc-cycles",
+	"/sched/pauses/total/gc:seconds",
+	"/sched/goroutines:goroutines",
+	"/gc/heap/allocs:bytes",
+}
+
+// runtimeSnapshot holds the values of runtimeDeltaMetrics at one point in time.
+type runtimeSnapshot struct {
+	gcCycles        uint64
+	gcPauses        []uint64
+	gcPauseBuckets  []float64
+	goroutines      uint64
+	heapAllocsBytes uint64
+}
+
+// readRuntimeSnapshot samples the runtime metrics needed to compute the
+// runtime delta fields of a root span.
+func readRuntimeSnapshot() *runtimeSnapshot {
+	samples := make([]metrics.Sample, len(runtimeDeltaMetrics))
+	for i, name := range runtimeDeltaMetrics {
+		samples[i].Name = name
+	}
+	metrics.Read(samples)
+
+	snap := &runtimeSnapshot{}
+	if samples[0].Value.Kind() == metrics.KindUint64 {
+		snap.gcCycles = samples[0].Value.Uint64()
+	}
+	if samples[1].Value.Kind() == metrics.KindFloat64Histogram {
+		h := samples[1].Value.Float64Histogram()
+		// the runtime may reuse the histogram, so keep our own copy of the
+		// counts
+		snap.gcPauses = append([]uint64(nil), h.Counts...)
+		snap.gcPauseBuckets = h.Buckets
+	}
+	if samples[2].Value.Kind() == metrics.KindUint64 {
+		snap.goroutines = samples[2].Value.Uint64()
+	}
+	if samples[3].Value.Kind() == metrics.KindUint64 {
+		snap.heapAllocsBytes = samples[3].Value.Uint64()
+	}
+	return snap
+}
+
+// runtimeDeltaFields compares a snapshot taken at the start of a span with the
+// current state of the runtime and returns the `runtime.*` fields describing
+// what happened in between. The Go runtime does not expose allocations per
+// goroutine, so allocated bytes are for the whole process.
+func runtimeDeltaFields(start *runtimeSnapshot) map[string]interface{} {
+	end := readRuntimeSnapshot()
+	pauseSecs := histogramDeltaSum(start.gcPauses, end.gcPauses, end.gcPauseBuckets)
+	return map[string]interface{}{
+		"runtime.gc_count":            end.gcCycles - start.gcCycles,
+		"runtime.gc_pause_ms":         pauseSecs * 1000,
+		"runtime.goroutines_start":    start.goroutines,
+		"runtime.goroutines_end":      end.goroutines,
+		"runtime.process_alloc_bytes": end.heapAllocsBytes - start.heapAllocsBytes,
+	}
+}
+
+// histogramDeltaSum estimates the total of the observations added to a
+// runtime/metrics histogram between two reads of its counts, taking each
+// observation to be in the middle of its bucket. Observations in an unbounded
+// bucket are taken to be at its finite bound.
+func histogramDeltaSum(start, end []uint64, buckets []float64) float64 {
+	var sum float64
+	for i, count := range end {
+		if i < len(start) {
+			count -= start[i]
+		}
+		if count == 0 || i+1 >= len(buckets) {
+			continue
+		}
+		lower, upper := buckets[i], buckets[i+1]
+		var value float64
+		switch {
+		case math.IsInf(lower, -1):
+			value = upper
+		case math.IsInf(upper, 1):
+			value = lower
+		default:
+			value = (lower + upper) / 2
+		}
+		sum += float64(count) * value
+	}
+	return sum
+}
@@ -5,7 +5,9 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"runtime/pprof"
+	rtrace "runtime/trace"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/honeycombio/beeline-go/client"
@@ -31,6 +33,26 @@ type Config struct {
 
 	// PprofTagging controls whether span IDs should be propagated to pprof.
 	PprofTagging bool
+
+	// RuntimeDeltas controls whether root spans get `runtime.*` fields
+	// describing what the Go runtime did while they were running: GC cycles,
+	// GC pause time, goroutine counts at start and end, and bytes allocated by
+	// the process.
+	RuntimeDeltas bool
+
+	// SlowSpanProfiling, if set, captures a profile of spans that run for
+	// longer than a threshold. See SlowSpanProfiling for details.
+	SlowSpanProfiling *SlowSpanProfiling
+
+	// ExecutionTracing controls whether each span opens a runtime/trace task,
+	// annotated with its trace ID, span ID and name, so that `go tool trace`
+	// output lines up with Honeycomb traces.
+	ExecutionTracing bool
+
+	// BaggageFields controls whether the W3C baggage a trace is continued with
+	// is added to the trace as trace-level fields, named `baggage.` followed
+	// by the baggage key.
+	BaggageFields bool
 }
 
 // Trace holds some trace level state and the root of the span tree that will be
@@ -47,6 +69,12 @@ type Trace struct {
 	rootSpan         *Span
 	tlfLock          sync.RWMutex
 	traceLevelFields map[string]interface{}
+	baggage          propagation.Baggage
+	traceState       propagation.TraceState
+	// localFields holds the keys of trace level fields that aren't propagated
+	localFields map[string]struct{}
+	// sampler replaces the global sampler for this trace, if set
+	sampler atomic.Pointer[sample.DeterministicSampler]
 }
 
 // getNewID generates a lowercase hex encoded string with the specified number
@@ -75,6 +103,17 @@ func NewTraceFromPropagationContext(ctx context.Context, prop *propagation.Propa
 		for k, v := range prop.TraceContext {
 			trace.traceLevelFields[k] = v
 		}
+		trace.baggage = prop.Baggage
+		trace.traceState = prop.TraceState
+		if GlobalConfig.BaggageFields && prop.Baggage.Len() > 0 {
+			// the baggage itself is passed downstream, so the fields are
+			// kept local rather than sent again with stale values
+			trace.localFields = make(map[string]struct{})
+			for _, m := range prop.Baggage.Members() {
+				trace.traceLevelFields["baggage."+m.Key] = m.Value
+				trace.localFields["baggage."+m.Key] = struct{}{}
+			}
+		}
 		if prop.Dataset != "" {
 			trace.builder.Dataset = prop.Dataset
 		}
@@ -91,9 +130,15 @@ func NewTraceFromPropagationContext(ctx context.Context, prop *propagation.Propa
 	}
 	rootSpan.ev = trace.builder.NewEvent()
 	rootSpan.trace = trace
+	if GlobalConfig.RuntimeDeltas {
+		rootSpan.runtimeStart = readRuntimeSnapshot()
+	}
 	trace.rootSpan = rootSpan
+	trackTrace(trace)
+	rootSpan.startProfileTimer()
 
 	// put trace and root span in context
+	ctx = rootSpan.startTask(ctx)
 	ctx = PutTraceInContext(ctx, trace)
 	ctx = PutSpanInContext(ctx, rootSpan)
 	return ctx, trace
@@ -127,25 +172,30 @@ func (t *Trace) AddField(key string, val interface{}) {
 	defer t.tlfLock.Unlock()
 	if t.traceLevelFields != nil {
 		t.traceLevelFields[key] = val
+		delete(t.localFields, key)
 	}
 }
 
-// serializeHeaders returns the trace ID, given span ID as parent ID, and an
-// encoded form of all trace level fields. This serialized header is intended
-// to be put in an HTTP (or other protocol) header to transmit to downstream
-// services so they may start a new trace that will be connected to this trace.
-// The serialized form may be passed to NewTrace() in order to create a new
-// trace that will be connected to this trace.
-func (t *Trace) serializeHeaders(spanID string) string {
-	prop := t.propagationContext()
-	prop.ParentID = spanID
-	return propagation.MarshalHoneycombTraceContext(prop)
+// AddLocalField adds a field to the trace, like AddField, but the field is not
+// passed along to downstream services. It is useful for fields that are too
+// large or sensitive to send in trace context headers.
+func (t *Trace) AddLocalField(key string, val interface{}) {
+	t.tlfLock.Lock()
+	defer t.tlfLock.Unlock()
+	if t.traceLevelFields != nil {
+		t.traceLevelFields[key] = val
+		if t.localFields == nil {
+			t.localFields = make(map[string]struct{})
+		}
+		t.localFields[key] = struct{}{}
+	}
 }
 
 // propagationContext returns a partially populated propagation context. It only
-// has the fields that come from the trace level - after getting the returned
-// object the caller must still fill in the span ID in order to fully populate
-// the PropagationContext struct for use creating serialized headers.
+// has the fields that come from the trace level, leaving out local fields -
+// after getting the returned object the caller must still fill in the span ID
+// in order to fully populate the PropagationContext struct for use creating
+// serialized headers.
 func (t *Trace) propagationContext() *propagation.PropagationContext {
 	// make a copy of the trace level fields map since we can't lock our
 	// returned value to protect it
@@ -153,14 +203,87 @@ func (t *Trace) propagationContext() *propagation.PropagationContext {
 	defer t.tlfLock.Unlock()
 	localTLF := map[string]interface{}{}
 	for k, v := range t.traceLevelFields {
-		localTLF[k] = v
+		if _, local := t.localFields[k]; !local {
+			localTLF[k] = v
+		}
 	}
 	return &propagation.PropagationContext{
 		TraceID:      t.traceID,
 		Dataset:      t.builder.Dataset,
 		TraceContext: localTLF,
 		TraceFlags:   propagation.FlagsSampled, // TODO: set the sampled flag based on sampler decision
+		Baggage:      t.baggage,
+		TraceState:   t.traceState,
+	}
+}
+
+// SetSampleRate sets the rate at which this trace is sampled, replacing the
+// sample rate the beeline was configured with, so that some kinds of requests
+// can be kept more or less often than others. As with the configured rate,
+// the decision is made from the trace ID, so all the spans of the trace are
+// kept or dropped together. A rate of 0 returns the trace to the configured
+// rate. It has no effect when a SamplerHook is configured.
+func (t *Trace) SetSampleRate(rate uint) {
+	if rate == 0 {
+		t.sampler.Store(nil)
+		return
 	}
+	sampler, _ := sample.NewDeterministicSampler(rate)
+	t.sampler.Store(sampler)
+}
+
+// GetBaggage returns the W3C baggage carried by this trace. It is propagated
+// to downstream services along with the trace.
+func (t *Trace) GetBaggage() propagation.Baggage {
+	t.tlfLock.RLock()
+	defer t.tlfLock.RUnlock()
+	return t.baggage
+}
+
+// SetBaggage replaces the W3C baggage carried by this trace. Use the methods
+// on propagation.Baggage to build the new baggage from GetBaggage.
+func (t *Trace) SetBaggage(baggage propagation.Baggage) {
+	t.tlfLock.Lock()
+	defer t.tlfLock.Unlock()
+	t.baggage = baggage
+}
+
+// GetTraceState returns the W3C tracestate carried by this trace. It holds the
+// entries of every vendor the trace has passed through, and is propagated to
+// downstream services along with the trace.
+func (t *Trace) GetTraceState() propagation.TraceState {
+	t.tlfLock.RLock()
+	defer t.tlfLock.RUnlock()
+	return t.traceState
+}
+
+// SetTraceState replaces the W3C tracestate carried by this trace.
+func (t *Trace) SetTraceState(ts propagation.TraceState) {
+	t.tlfLock.Lock()
+	defer t.tlfLock.Unlock()
+	t.traceState = ts
+}
+
+// GetTraceStateValue returns the value of a single vendor's entry in the
+// trace's tracestate, eg `hny`, or an empty string if there is none.
+func (t *Trace) GetTraceStateValue(key string) string {
+	return t.GetTraceState().Get(key)
+}
+
+// SetTraceStateValue sets a single vendor's entry in the trace's tracestate,
+// moving it to the front as the W3C Trace Context specification requires of
+// the entry of the vendor that last modified the trace. Other vendors' entries
+// are kept. It returns an error, leaving the tracestate unchanged, if the key
+// or value is invalid or the tracestate is full.
+func (t *Trace) SetTraceStateValue(key, value string) error {
+	t.tlfLock.Lock()
+	defer t.tlfLock.Unlock()
+	ts, err := t.traceState.Insert(key, value)
+	if err != nil {
+		return err
+	}
+	t.traceState = ts
+	return nil
 }
 
 // addRollupField is here to let a span contribute a field to the trace while
@@ -243,6 +366,11 @@ type Span struct {
 	eventLock    sync.Mutex
 	sendLock     sync.RWMutex
 	oldCtx       *context.Context
+	runtimeStart *runtimeSnapshot
+	profileTimer *time.Timer
+	task         *rtrace.Task
+	taskCtx      context.Context
+	annotations  []*libhoney.Event
 }
 
 // newSpan takes care of *some* of the initialization necessary to create a new
@@ -264,6 +392,9 @@ func newSpan() *Span {
 func (s *Span) AddField(key string, val interface{}) {
 	// The call to event's AddField is protected by a lock, but this is not always sufficient
 	// See send for why this lock exists
+	if key == "name" {
+		s.logTaskName(val)
+	}
 	s.eventLock.Lock()
 	defer s.eventLock.Unlock()
 	if s.ev != nil {
@@ -279,6 +410,9 @@ func (s *Span) AddField(key string, val interface{}) {
 // lock acquisition. More efficient than calling AddField in a loop.
 // Errors in the map are converted to their message string, matching AddField.
 func (s *Span) AddFields(fields map[string]interface{}) {
+	if name, ok := fields["name"]; ok {
+		s.logTaskName(name)
+	}
 	s.eventLock.Lock()
 	defer s.eventLock.Unlock()
 	if s.ev != nil {
@@ -354,10 +488,16 @@ func (s *Span) sendLocked() {
 	if s.ev == nil {
 		return
 	}
+	// a span that is finishing no longer needs profiling
+	if s.profileTimer != nil {
+		s.profileTimer.Stop()
+	}
 	// finish the timer for this span
 	if !s.started.IsZero() {
-		dur := float64(time.Since(s.started)) / float64(time.Millisecond)
+		elapsed := time.Since(s.started)
+		dur := float64(elapsed) / float64(time.Millisecond)
 		s.AddField("duration_ms", dur)
+		s.checkFlightRecorder(elapsed)
 	}
 	// set trace IDs for this span
 	s.ev.AddField("trace.trace_id", s.trace.traceID)
@@ -392,12 +532,17 @@ func (s *Span) sendLocked() {
 
 	s.send()
 	s.isSent = true
+	if s.isRoot {
+		untrackTrace(s.trace)
+	}
 
 	// Remove this span from its parent's children list so that it can be GC'd
 	if s.parent != nil {
 		s.parent.removeChildSpan(s)
 	}
 
+	s.endTask()
+
 	// Restore pprof labels from before this span was created, if any were saved.
 	if s.oldCtx != nil {
 		pprof.SetGoroutineLabels(*s.oldCtx)
@@ -455,7 +600,7 @@ func (s *Span) CreateChild(ctx context.Context) (context.Context, *Span) {
 // The serialized form may be passed to NewTrace() in order to create a new
 // trace that will be connected to this trace.
 func (s *Span) SerializeHeaders() string {
-	return s.trace.serializeHeaders(s.spanID)
+	return propagation.MarshalHoneycombTraceContext(s.PropagationContext())
 }
 
 // removeChildSpan remove a child which has been sent. It is intended to be
@@ -509,6 +654,9 @@ func (s *Span) send() {
 		for k, v := range s.trace.getRollupFields() {
 			s.AddField("rollup."+k, v)
 		}
+		if s.runtimeStart != nil {
+			s.AddFields(runtimeDeltaFields(s.runtimeStart))
+		}
 	}
 
 	// Because we hand a raw map over to the Sampler and Presend hooks, it's
@@ -523,6 +671,10 @@ func (s *Span) send() {
 		var sampleRate int
 		shouldKeep, sampleRate = GlobalConfig.SamplerHook(s.ev.Fields())
 		s.ev.SampleRate = uint(sampleRate)
+	} else if sampler := s.trace.sampler.Load(); sampler != nil {
+		// use the sample rate set for this trace
+		shouldKeep = sampler.Sample(s.trace.traceID)
+		s.ev.SampleRate = uint(sampler.GetSampleRate())
 	} else {
 		// use the default sampler
 		if sample.GlobalSampler != nil {
@@ -536,6 +688,9 @@ func (s *Span) send() {
 			GlobalConfig.PresendHook(s.ev.Fields())
 		}
 		s.ev.SendPresampled()
+		s.sendAnnotationsLocked()
+	} else {
+		s.annotations = nil
 	}
 }
 
@@ -549,14 +704,21 @@ func (s *Span) createChildSpan(ctx context.Context, async bool) (context.Context
 	s.childrenLock.Lock()
 	s.children = append(s.children, newSpan)
 	s.childrenLock.Unlock()
+	newSpan.startProfileTimer()
+	ctx = newSpan.startTask(ctx)
 	ctx = PutSpanInContext(ctx, newSpan)
 	return ctx, newSpan
 }
 
 // PropagationContext creates and returns a new propagation.PropagationContext using the
-// information in the current span.
+// information in the current span. It only has the trace level fields that
+// propagation.GlobalConfig.TraceFields allows; if any had to be dropped to fit
+// its size limit, the span gets a `meta.propagation_truncated` field.
 func (s *Span) PropagationContext() *propagation.PropagationContext {
 	prop := s.trace.propagationContext()
 	prop.ParentID = s.spanID
+	if propagation.GlobalConfig.TraceFields.Apply(prop) {
+		s.AddField("meta.propagation_truncated", true)
+	}
 	return prop
 }
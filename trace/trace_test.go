@@ -1,10 +1,15 @@
 package trace
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"math"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
 	"strings"
 	"sync"
 	"testing"
@@ -12,6 +17,7 @@ import (
 
 	"github.com/honeycombio/beeline-go/client"
 	"github.com/honeycombio/beeline-go/propagation"
+	"github.com/honeycombio/beeline-go/sample"
 	libhoney "github.com/honeycombio/libhoney-go"
 	"github.com/honeycombio/libhoney-go/transmission"
 	"github.com/stretchr/testify/assert"
@@ -659,6 +665,53 @@ func TestSerializePropagation(t *testing.T) {
 	assert.NotZero(t, prop, "span propagation context should not be empty")
 }
 
+func TestAddLocalField(t *testing.T) {
+	mo := setupLibhoney()
+	_, tr := NewTrace(context.Background(), nil)
+	tr.AddField("shared", 1)
+	tr.AddLocalField("local", 2)
+	sp := tr.GetRootSpan()
+
+	assert.Equal(t, map[string]interface{}{"shared": 1}, sp.PropagationContext().TraceContext, "local fields aren't propagated")
+
+	tr.AddField("local", 3)
+	assert.Equal(t, map[string]interface{}{"shared": 1, "local": 3}, sp.PropagationContext().TraceContext, "adding a field again propagates it")
+
+	tr.AddLocalField("shared", 4)
+	sp.Send()
+	evs := mo.Events()
+	assert.Equal(t, 1, len(evs))
+	assert.Equal(t, 4, evs[0].Data["shared"], "local fields are added to spans")
+	assert.Equal(t, 3, evs[0].Data["local"])
+}
+
+func TestPropagationTruncated(t *testing.T) {
+	mo := setupLibhoney()
+	defer func() { propagation.GlobalConfig.TraceFields = propagation.TraceFieldPolicy{} }()
+	propagation.GlobalConfig.TraceFields = propagation.TraceFieldPolicy{MaxHeaderBytes: 200}
+
+	_, tr := NewTrace(context.Background(), nil)
+	tr.AddField("small", "a")
+	sp := tr.GetRootSpan()
+	_, child := sp.CreateChild(context.Background())
+	assert.Equal(t, map[string]interface{}{"small": "a"}, child.PropagationContext().TraceContext)
+
+	tr.AddField("large", strings.Repeat("x", 200))
+	header := sp.SerializeHeaders()
+	assert.LessOrEqual(t, len(header), 200)
+	prop, err := propagation.UnmarshalHoneycombTraceContext(header)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"small": "a"}, prop.TraceContext)
+
+	child.Send()
+	sp.Send()
+	evs := mo.Events()
+	assert.Equal(t, 2, len(evs))
+	assert.Nil(t, evs[0].Data["meta.propagation_truncated"], "only spans that dropped fields are marked")
+	assert.Equal(t, true, evs[1].Data["meta.propagation_truncated"])
+	assert.Equal(t, strings.Repeat("x", 200), evs[1].Data["large"], "dropped fields are still added to spans")
+}
+
 func TestSerializePropagationDoesNotRaceWithTraceFields(t *testing.T) {
 	_, tr := NewTrace(context.Background(), nil)
 	sp := tr.GetRootSpan()
@@ -700,6 +753,297 @@ func TestSerializePropagationDoesNotRaceWithTraceFields(t *testing.T) {
 	wg.Wait()
 }
 
+// TestRuntimeDeltas verifies that root spans get runtime delta fields when
+// enabled and that child spans do not.
+func TestRuntimeDeltas(t *testing.T) {
+	mo := setupLibhoney()
+	GlobalConfig.RuntimeDeltas = true
+	defer func() { GlobalConfig.RuntimeDeltas = false }()
+
+	ctx, tr := NewTrace(context.Background(), nil)
+	_, child := tr.GetRootSpan().CreateChild(ctx)
+	runtime.GC()
+	child.Send()
+	tr.Send()
+
+	events := mo.Events()
+	assert.Equal(t, 2, len(events), "should have sent the root and child spans")
+	childFields, rootFields := events[0].Data, events[1].Data
+	assert.NotContains(t, childFields, "runtime.gc_count", "only root spans get runtime deltas")
+	gcCount, ok := rootFields["runtime.gc_count"].(uint64)
+	assert.True(t, ok, "root span should have runtime.gc_count")
+	assert.GreaterOrEqual(t, gcCount, uint64(1), "root span should count the GC that ran")
+	assert.Greater(t, rootFields["runtime.gc_pause_ms"], 0.0, "the GC should have paused")
+	assert.Contains(t, rootFields, "runtime.goroutines_start")
+	assert.Contains(t, rootFields, "runtime.goroutines_end")
+	assert.Contains(t, rootFields, "runtime.process_alloc_bytes")
+}
+
+func TestHistogramDeltaSum(t *testing.T) {
+	buckets := []float64{math.Inf(-1), 0, 0.002, 0.004, math.Inf(1)}
+	start := []uint64{0, 1, 2, 3}
+	end := []uint64{0, 3, 2, 4}
+	// two pauses in [0, 0.002) and one in [0.004, +Inf)
+	assert.InDelta(t, 2*0.001+0.004, histogramDeltaSum(start, end, buckets), 1e-9)
+	assert.Equal(t, 0.0, histogramDeltaSum(end, end, buckets))
+	assert.Equal(t, 0.0, histogramDeltaSum(nil, nil, nil), "a missing metric has no pauses")
+}
+
+// TestSlowSpanProfiling verifies that a span running past the threshold gets
+// profiled and that the profile is linked from the span.
+func TestSlowSpanProfiling(t *testing.T) {
+	mo := setupLibhoney()
+	profiles := make(chan *Profile, 1)
+	dir := t.TempDir()
+	GlobalConfig.SlowSpanProfiling = &SlowSpanProfiling{
+		Threshold:   time.Millisecond,
+		Kind:        ProfileGoroutine,
+		MinInterval: time.Nanosecond,
+		Dir:         dir,
+		Handler: func(p *Profile) {
+			profiles <- p
+		},
+	}
+	defer func() { GlobalConfig.SlowSpanProfiling = nil }()
+
+	_, tr := NewTrace(context.Background(), nil)
+	var p *Profile
+	select {
+	case p = <-profiles:
+	case <-time.After(time.Second):
+		t.Fatal("slow span was not profiled")
+	}
+	tr.Send()
+
+	assert.Equal(t, tr.GetTraceID(), p.TraceID)
+	assert.Equal(t, tr.GetRootSpan().GetSpanID(), p.SpanID)
+	assert.NotEmpty(t, p.Data, "profile should have data")
+	assert.FileExists(t, p.Path)
+
+	events := mo.Events()
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, p.ID, events[0].Data["profile.id"])
+	assert.Equal(t, p.Path, events[0].Data["profile.path"])
+	assert.Equal(t, "goroutine", events[0].Data["profile.kind"])
+
+	assert.False(t, tr.GetRootSpan().addProfileFields(p), "profiles of spans already sent are dropped")
+}
+
+// TestSlowSpanProfilingFailures verifies that a profile that can't be taken
+// doesn't use up the rate limit, and that a profile that can't be written
+// isn't linked from the span.
+func TestSlowSpanProfilingFailures(t *testing.T) {
+	mo := setupLibhoney()
+	profiles := make(chan *Profile, 1)
+	cfg := &SlowSpanProfiling{
+		Threshold:   time.Hour,
+		Kind:        ProfileGoroutine,
+		MinInterval: time.Hour,
+		Dir:         filepath.Join(t.TempDir(), "missing"),
+		Handler: func(p *Profile) {
+			profiles <- p
+		},
+	}
+	GlobalConfig.SlowSpanProfiling = cfg
+	defer func() { GlobalConfig.SlowSpanProfiling = nil }()
+	lastProfileTime.Store(0)
+	defer lastProfileTime.Store(0)
+
+	// another CPU profile is already running
+	var other bytes.Buffer
+	if err := pprof.StartCPUProfile(&other); err != nil {
+		t.Skip("can't start a CPU profile:", err)
+	}
+	_, tr := NewTrace(context.Background(), nil)
+	tr.GetRootSpan().profile(&SlowSpanProfiling{MinInterval: time.Hour})
+	pprof.StopCPUProfile()
+	assert.Equal(t, int64(0), lastProfileTime.Load(), "a failed profile should give back its slot")
+
+	tr.GetRootSpan().profile(cfg)
+	p := <-profiles
+	tr.Send()
+	assert.NotEmpty(t, p.Data)
+	assert.Equal(t, "", p.Path, "the profile could not be written")
+	events := mo.Events()
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, p.ID, events[0].Data["profile.id"])
+	assert.NotContains(t, events[0].Data, "profile.path")
+}
+
+// TestExecutionTracing verifies that spans open runtime/trace tasks, and that
+// the flight recorder dumps an execution trace for slow spans.
+func TestExecutionTracing(t *testing.T) {
+	mo := setupLibhoney()
+	GlobalConfig.ExecutionTracing = true
+	defer func() { GlobalConfig.ExecutionTracing = false }()
+	profiles := make(chan *Profile, 1)
+	err := StartFlightRecorder(&FlightRecording{
+		SlowSpanThreshold: time.Millisecond,
+		MinInterval:       time.Nanosecond,
+		Dir:               t.TempDir(),
+		Handler: func(p *Profile) {
+			profiles <- p
+		},
+	})
+	if err != nil {
+		t.Skip("flight recorder unavailable:", err)
+	}
+	defer StopFlightRecorder()
+
+	ctx, tr := NewTrace(context.Background(), nil)
+	assert.NotNil(t, tr.GetRootSpan().task, "root span should have a task")
+	_, child := tr.GetRootSpan().CreateChild(ctx)
+	child.AddField("name", "child")
+	assert.NotNil(t, child.task, "child span should have a task")
+	child.Send()
+	time.Sleep(2 * time.Millisecond)
+	tr.Send()
+
+	var p *Profile
+	select {
+	case p = <-profiles:
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow span did not dump the flight recorder")
+	}
+	assert.Equal(t, tr.GetTraceID(), p.TraceID)
+	assert.Equal(t, tr.GetRootSpan().GetSpanID(), p.SpanID)
+	assert.Equal(t, ProfileExecutionTrace, p.Kind)
+	assert.NotEmpty(t, p.Data, "execution trace should have data")
+
+	events := mo.Events()
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, p.ID, events[1].Data["trace_dump.id"])
+	assert.Equal(t, p.Path, events[1].Data["trace_dump.path"])
+	assert.FileExists(t, p.Path)
+	assert.NotContains(t, events[1].Data, "profile.id")
+}
+
+// TestSpanEvents verifies that span events are sent after their span with the
+// span as their parent.
+func TestSpanEvents(t *testing.T) {
+	mo := setupLibhoney()
+	_, tr := NewTrace(context.Background(), nil)
+	rs := tr.GetRootSpan()
+	rs.AddField("name", "root")
+	fields := map[string]interface{}{"attempt": 2, "err": errors.New("boom")}
+	rs.AddSpanEvent("retry", fields)
+	tr.Send()
+
+	events := mo.Events()
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, "root", events[0].Data["name"])
+	ev := events[1].Data
+	assert.Equal(t, "retry", ev["name"])
+	assert.Equal(t, "span_event", ev["meta.annotation_type"])
+	assert.Equal(t, tr.GetTraceID(), ev["trace.trace_id"])
+	assert.Equal(t, rs.GetSpanID(), ev["trace.parent_id"])
+	assert.Equal(t, 2, ev["attempt"])
+	assert.Equal(t, "boom", ev["err"])
+	assert.IsType(t, errors.New(""), fields["err"], "caller's map should not be modified")
+}
+
+func TestLinks(t *testing.T) {
+	mo := setupLibhoney()
+	_, tr := NewTrace(context.Background(), nil)
+	rs := tr.GetRootSpan()
+	rs.AddLink("othertrace", "otherspan", map[string]interface{}{"link.kind": "producer"})
+	tr.Send()
+
+	events := mo.Events()
+	assert.Equal(t, 2, len(events))
+	ev := events[1].Data
+	assert.Equal(t, "link", ev["meta.annotation_type"])
+	assert.Equal(t, "othertrace", ev["trace.link.trace_id"])
+	assert.Equal(t, "otherspan", ev["trace.link.span_id"])
+	assert.Equal(t, rs.GetSpanID(), ev["trace.parent_id"])
+	assert.Equal(t, "producer", ev["link.kind"])
+	assert.NotContains(t, ev, "name")
+}
+
+func TestBaggage(t *testing.T) {
+	mo := setupLibhoney()
+	GlobalConfig.BaggageFields = true
+	defer func() { GlobalConfig.BaggageFields = false }()
+
+	baggage, _ := propagation.ParseBaggage("tenant=acme")
+	ctx, tr := NewTrace(context.Background(), &propagation.PropagationContext{Baggage: baggage})
+	_, child := tr.GetRootSpan().CreateChild(ctx)
+	assert.Equal(t, "acme", child.PropagationContext().Baggage.Get("tenant"), "baggage should be propagated")
+	assert.NotContains(t, child.PropagationContext().TraceContext, "baggage.tenant", "baggage fields should not be propagated")
+
+	baggage, _ = tr.GetBaggage().Set("region", "eu")
+	tr.SetBaggage(baggage)
+	assert.Equal(t, "eu", child.PropagationContext().Baggage.Get("region"))
+	child.Send()
+	tr.Send()
+
+	for _, ev := range mo.Events() {
+		assert.Equal(t, "acme", ev.Data["baggage.tenant"])
+		assert.NotContains(t, ev.Data, "baggage.region", "only incoming baggage becomes fields")
+	}
+}
+
+func TestTraceState(t *testing.T) {
+	setupLibhoney()
+	ts, _ := propagation.ParseTraceState("rojo=00f067aa0ba902b7,congo=t61rcWkgMzE")
+	ctx, tr := NewTrace(context.Background(), &propagation.PropagationContext{
+		TraceID:    "0af7651916cd43dd8448eb211c80319c",
+		ParentID:   "b7ad6b7169203331",
+		TraceState: ts,
+	})
+	_, child := tr.GetRootSpan().CreateChild(ctx)
+	assert.Equal(t, "rojo=00f067aa0ba902b7,congo=t61rcWkgMzE", child.PropagationContext().TraceState.String(),
+		"tracestate should survive the hop")
+
+	assert.Equal(t, "", tr.GetTraceStateValue("hny"))
+	assert.NoError(t, tr.SetTraceStateValue("hny", "abc"))
+	assert.Equal(t, "abc", tr.GetTraceStateValue("hny"))
+	assert.NoError(t, tr.SetTraceStateValue("rojo", "00f067aa0ba902b8"))
+	assert.Equal(t, "rojo=00f067aa0ba902b8,hny=abc,congo=t61rcWkgMzE", child.PropagationContext().TraceState.String(),
+		"updated entries should move to the front")
+	assert.Error(t, tr.SetTraceStateValue("Invalid Key", "x"))
+	assert.Equal(t, 3, tr.GetTraceState().Len())
+
+	tr.SetTraceState(tr.GetTraceState().Delete("congo"))
+	_, headers := propagation.MarshalW3CTraceContext(ctx, child.PropagationContext())
+	assert.Equal(t, "rojo=00f067aa0ba902b8,hny=abc", headers[propagation.TracestateHeader])
+}
+
+func TestSetSampleRate(t *testing.T) {
+	mo := setupLibhoney()
+	sampler, _ := sample.NewDeterministicSampler(4)
+
+	// find a trace ID that a rate of 4 keeps and one it drops
+	var kept, dropped string
+	for kept == "" || dropped == "" {
+		id := getNewID(traceIDLengthBytes)
+		if sampler.Sample(id) {
+			kept = id
+		} else {
+			dropped = id
+		}
+	}
+	for _, id := range []string{kept, dropped} {
+		ctx, tr := NewTrace(context.Background(), &propagation.PropagationContext{TraceID: id})
+		tr.SetSampleRate(4)
+		_, child := tr.GetRootSpan().CreateChild(ctx)
+		child.Send()
+		tr.Send()
+	}
+	evs := mo.Events()
+	assert.Equal(t, 2, len(evs), "the spans of the kept trace are sent")
+	for _, ev := range evs {
+		assert.Equal(t, kept, ev.Data["trace.trace_id"])
+		assert.Equal(t, uint(4), ev.SampleRate)
+	}
+
+	_, tr := NewTrace(context.Background(), &propagation.PropagationContext{TraceID: dropped})
+	tr.SetSampleRate(4)
+	tr.SetSampleRate(0)
+	tr.Send()
+	assert.Equal(t, 3, len(mo.Events()), "a rate of 0 goes back to the configured sampler")
+}
+
 // TestGetNewID ensures that ID is always a lowercase hex string of the requested length
 func TestGetNewID(t *testing.T) {
 	id := getNewID(8)
@@ -0,0 +1,381 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/felixge/httpsnoop"
+	"github.com/honeycombio/beeline-go/wrappers/config"
+)
+
+// DefaultBodyCaptureMaxBytes is the most bytes captured from a body when
+// config.HTTPBodyCapture.MaxBytes is not set.
+const DefaultBodyCaptureMaxBytes = 4096
+
+// BodyCapture records the request and response bodies of a single HTTP
+// request, as selected by a config.HTTPBodyCapture. All its methods may be
+// called on a nil *BodyCapture, which captures nothing, so callers needn't
+// check whether capture is enabled.
+type BodyCapture struct {
+	cfg     config.HTTPBodyCapture
+	request *bodyRecorder
+
+	// mu guards the response recorder, which response writers create on the
+	// first write, once the content type is known
+	mu              sync.Mutex
+	response        *bodyRecorder
+	responseChecked bool
+}
+
+// NewBodyCapture returns a BodyCapture for a request, or nil if cfg captures
+// no bodies or the request wasn't chosen by its SampleRate.
+func NewBodyCapture(cfg config.HTTPBodyCapture) *BodyCapture {
+	if len(cfg.ContentTypes) == 0 {
+		return nil
+	}
+	if cfg.SampleRate > 1 && rand.Intn(int(cfg.SampleRate)) != 0 {
+		return nil
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = DefaultBodyCaptureMaxBytes
+	}
+	return &BodyCapture{cfg: cfg}
+}
+
+// newRecorder returns a recorder for a body with the given Content-Type, or
+// nil if bodies of that type aren't captured.
+func (bc *BodyCapture) newRecorder(contentType string) *bodyRecorder {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	if !matchesMediaType(bc.cfg.ContentTypes, mediaType) {
+		return nil
+	}
+	return &bodyRecorder{
+		max:  bc.cfg.MaxBytes,
+		json: mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"),
+		form: mediaType == "application/x-www-form-urlencoded",
+	}
+}
+
+// CaptureRequestBody replaces the body of r so that it is recorded as it is
+// read, if its content type is captured.
+func (bc *BodyCapture) CaptureRequestBody(r *http.Request) {
+	if bc == nil || r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	if bc.request = bc.newRecorder(r.Header.Get("Content-Type")); bc.request != nil {
+		r.Body = teeReadCloser{io.TeeReader(r.Body, bc.request), r.Body}
+	}
+}
+
+// CaptureResponseBody replaces the body of resp so that it is recorded as it
+// is read, if its content type is captured.
+func (bc *BodyCapture) CaptureResponseBody(resp *http.Response) {
+	if bc == nil || resp.Body == nil || resp.Body == http.NoBody {
+		return
+	}
+	rec := bc.newRecorder(resp.Header.Get("Content-Type"))
+	bc.mu.Lock()
+	bc.response = rec
+	bc.mu.Unlock()
+	if rec != nil {
+		resp.Body = teeReadCloser{io.TeeReader(resp.Body, rec), resp.Body}
+	}
+}
+
+// CapturesResponseBody reports whether the response body is being recorded.
+func (bc *BodyCapture) CapturesResponseBody() bool {
+	if bc == nil {
+		return false
+	}
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+	return bc.response != nil
+}
+
+// RecordResponseBody records p, written as part of a response with headers
+// h, if the content type of the response is captured. The content type is
+// sniffed from p if h doesn't set it. It is for response writers that can't
+// be wrapped with WrapResponseWriter.
+func (bc *BodyCapture) RecordResponseBody(h http.Header, p []byte) {
+	if bc == nil {
+		return
+	}
+	bc.mu.Lock()
+	if !bc.responseChecked {
+		bc.responseChecked = true
+		contentType := h.Get("Content-Type")
+		if contentType == "" {
+			contentType = http.DetectContentType(p)
+		}
+		bc.response = bc.newRecorder(contentType)
+	}
+	rec := bc.response
+	bc.mu.Unlock()
+	if rec != nil {
+		rec.Write(p)
+	}
+}
+
+// WrapResponseWriter returns a http.ResponseWriter that records the response
+// body written to w, keeping the optional interfaces w implements.
+func (bc *BodyCapture) WrapResponseWriter(w http.ResponseWriter) http.ResponseWriter {
+	if bc == nil {
+		return w
+	}
+	return httpsnoop.Wrap(w, httpsnoop.Hooks{
+		Write: func(next httpsnoop.WriteFunc) httpsnoop.WriteFunc {
+			return func(p []byte) (int, error) {
+				n, err := next(p)
+				bc.RecordResponseBody(w.Header(), p[:n])
+				return n, err
+			}
+		},
+		ReadFrom: func(next httpsnoop.ReadFromFunc) httpsnoop.ReadFromFunc {
+			return func(src io.Reader) (int64, error) {
+				return next(io.TeeReader(src, bodyCaptureWriter{bc, w.Header()}))
+			}
+		},
+	})
+}
+
+// Fields returns the captured bodies as span fields.
+func (bc *BodyCapture) Fields() map[string]interface{} {
+	if bc == nil {
+		return nil
+	}
+	props := make(map[string]interface{})
+	bc.request.addFields(props, "request.", bc.cfg.RedactKeys)
+	bc.mu.Lock()
+	rec := bc.response
+	bc.mu.Unlock()
+	rec.addFields(props, "response.", bc.cfg.RedactKeys)
+	return props
+}
+
+// bodyCaptureWriter records the bytes written to it as part of a response.
+type bodyCaptureWriter struct {
+	bc *BodyCapture
+	h  http.Header
+}
+
+func (w bodyCaptureWriter) Write(p []byte) (int, error) {
+	w.bc.RecordResponseBody(w.h, p)
+	return len(p), nil
+}
+
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// bodyRecorder keeps up to max bytes of a body written to it, discarding the
+// rest. Writes never fail.
+type bodyRecorder struct {
+	max  int
+	json bool
+	form bool
+
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (br *bodyRecorder) Write(p []byte) (int, error) {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	remaining := br.max - br.buf.Len()
+	if len(p) > remaining {
+		br.truncated = true
+		br.buf.Write(p[:remaining])
+	} else {
+		br.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// addFields adds the recorded body to props, with the values of JSON and form
+// keys matching redactKeys replaced. A JSON body that can't be parsed is only
+// included up to where parsing failed, and flagged as unparsed.
+func (br *bodyRecorder) addFields(props map[string]interface{}, prefix string, redactKeys []string) {
+	if br == nil {
+		return
+	}
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	body := br.buf.String()
+	if len(redactKeys) > 0 {
+		switch {
+		case br.json:
+			var err error
+			body, err = redactJSON(br.buf.Bytes(), redactKeys)
+			// a truncated body is expected to end mid-value
+			if err != nil && !(br.truncated && err == io.ErrUnexpectedEOF) {
+				props[prefix+"body_unparsed"] = true
+			}
+		case br.form:
+			body = redactForm(body, redactKeys)
+		}
+	}
+	props[prefix+"body"] = body
+	props[prefix+"body_truncated"] = br.truncated
+}
+
+// matchesMediaType reports whether mediaType matches any of patterns, where a
+// pattern subtype of "*" matches any subtype.
+func matchesMediaType(patterns []string, mediaType string) bool {
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		if p == mediaType || p == "*/*" ||
+			(strings.HasSuffix(p, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(p, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesKey reports whether key matches any of patterns, ignoring case.
+func matchesKey(patterns []string, key string) bool {
+	key = strings.ToLower(key)
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// redactForm replaces the values of the keys of a URL encoded form matching
+// patterns with RedactedHeaderValue. Keys that can't be decoded, such as one
+// cut off mid escape, have their values replaced too.
+func redactForm(body string, patterns []string) string {
+	pairs := strings.Split(body, "&")
+	for i, pair := range pairs {
+		rawKey, _, hasValue := strings.Cut(pair, "=")
+		if !hasValue {
+			continue
+		}
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil || matchesKey(patterns, key) {
+			pairs[i] = rawKey + "=" + RedactedHeaderValue
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// redactJSON rewrites body as compact JSON with the values of the object keys
+// matching patterns replaced by RedactedHeaderValue. It works token by token
+// so that a body truncated mid-value is rewritten up to where it was cut
+// off; nothing after the last complete token is included, so a truncated
+// value is never leaked. It returns the error that stopped parsing before the
+// end of body, if any.
+func redactJSON(body []byte, patterns []string) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var out bytes.Buffer
+
+	// containers holds the open objects and arrays, and how many keys and
+	// values have been written to each
+	type container struct {
+		object bool
+		n      int
+	}
+	var containers []container
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return out.String(), nil
+		}
+		if err != nil {
+			return out.String(), err
+		}
+		if d, ok := tok.(json.Delim); ok && (d == '}' || d == ']') {
+			containers = containers[:len(containers)-1]
+			out.WriteByte(byte(d))
+			continue
+		}
+
+		var top *container
+		if len(containers) > 0 {
+			top = &containers[len(containers)-1]
+			switch {
+			case top.object && top.n%2 == 1:
+				out.WriteByte(':')
+			case top.n > 0:
+				out.WriteByte(',')
+			}
+			top.n++
+		} else if out.Len() > 0 {
+			// a stream of several values, such as newline delimited JSON
+			out.WriteByte('\n')
+		}
+
+		if key, ok := tok.(string); ok && top != nil && top.object && top.n%2 == 1 {
+			writeJSONValue(&out, key)
+			if matchesKey(patterns, key) {
+				out.WriteByte(':')
+				writeJSONValue(&out, RedactedHeaderValue)
+				top.n++
+				if err := skipJSONValue(dec); err != nil {
+					return out.String(), err
+				}
+			}
+			continue
+		}
+
+		switch tok {
+		case json.Delim('{'):
+			out.WriteByte('{')
+			containers = append(containers, container{object: true})
+		case json.Delim('['):
+			out.WriteByte('[')
+			containers = append(containers, container{})
+		default:
+			writeJSONValue(&out, tok)
+		}
+	}
+}
+
+// skipJSONValue reads the next value from dec, returning an error if it
+// couldn't be read completely.
+func skipJSONValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+// writeJSONValue writes v to out as JSON, without escaping HTML characters.
+func writeJSONValue(out *bytes.Buffer, v interface{}) {
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+	// Encode adds a trailing newline
+	out.Truncate(out.Len() - 1)
+}
@@ -0,0 +1,150 @@
+package common
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/honeycombio/beeline-go/wrappers/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBodyCapture(t *testing.T) {
+	assert.Nil(t, NewBodyCapture(config.HTTPBodyCapture{}), "nothing is captured by default")
+	assert.NotNil(t, NewBodyCapture(config.HTTPBodyCapture{ContentTypes: []string{"text/*"}, SampleRate: 1}))
+
+	var bc *BodyCapture
+	assert.Nil(t, bc.Fields())
+	assert.False(t, bc.CapturesResponseBody())
+	w := httptest.NewRecorder()
+	assert.Equal(t, w, bc.WrapResponseWriter(w), "a nil BodyCapture leaves writers as they are")
+}
+
+func TestBodyCapture(t *testing.T) {
+	bc := NewBodyCapture(config.HTTPBodyCapture{
+		ContentTypes: []string{"application/json", "text/*"},
+		MaxBytes:     10,
+		RedactKeys:   []string{"password"},
+	})
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"user":"a","password":"hunter2"}`))
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	bc.CaptureRequestBody(r)
+	body, err := io.ReadAll(r.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"user":"a","password":"hunter2"}`, string(body), "the handler reads the whole body")
+
+	rec := httptest.NewRecorder()
+	w := bc.WrapResponseWriter(rec)
+	io.WriteString(w, "hello ")
+	io.WriteString(w, "world")
+	assert.Equal(t, "hello world", rec.Body.String())
+
+	assert.Equal(t, map[string]interface{}{
+		"request.body":            `{"user"`,
+		"request.body_truncated":  true,
+		"response.body":           "hello worl",
+		"response.body_truncated": true,
+	}, bc.Fields())
+
+	t.Run("content types not captured", func(t *testing.T) {
+		bc := NewBodyCapture(config.HTTPBodyCapture{ContentTypes: []string{"application/json"}})
+		r := httptest.NewRequest("POST", "/", strings.NewReader("a=b"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		bc.CaptureRequestBody(r)
+		io.ReadAll(r.Body)
+		w := bc.WrapResponseWriter(httptest.NewRecorder())
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+		assert.Empty(t, bc.Fields())
+	})
+
+	t.Run("sniffed content type", func(t *testing.T) {
+		bc := NewBodyCapture(config.HTTPBodyCapture{ContentTypes: []string{"text/html"}})
+		w := bc.WrapResponseWriter(httptest.NewRecorder())
+		w.Write([]byte("<html></html>"))
+		assert.Equal(t, "<html></html>", bc.Fields()["response.body"])
+	})
+
+	t.Run("client response", func(t *testing.T) {
+		bc := NewBodyCapture(config.HTTPBodyCapture{ContentTypes: []string{"application/*"}})
+		resp := &http.Response{
+			Header: http.Header{"Content-Type": []string{"application/problem+json"}},
+			Body:   io.NopCloser(strings.NewReader(`{"title":"oops"}`)),
+		}
+		bc.CaptureResponseBody(resp)
+		assert.True(t, bc.CapturesResponseBody())
+		io.ReadAll(resp.Body)
+		assert.Equal(t, `{"title":"oops"}`, bc.Fields()["response.body"])
+		assert.Equal(t, false, bc.Fields()["response.body_truncated"])
+	})
+}
+
+func TestRedactJSON(t *testing.T) {
+	patterns := []string{"password", "*Token*"}
+	testCases := []struct {
+		body     string
+		expected string
+		err      error
+	}{
+		{`{"user": "a", "password": "hunter2"}`, `{"user":"a","password":"[REDACTED]"}`, nil},
+		{`{"auth": {"accessToken": {"value": "x"}, "ttl": 10}}`, `{"auth":{"accessToken":"[REDACTED]","ttl":10}}`, nil},
+		{`[{"PASSWORD": ["a", "b"]}, 1.5, null, true]`, `[{"PASSWORD":"[REDACTED]"},1.5,null,true]`, nil},
+		{`{"query": "a<b"}`, `{"query":"a<b"}`, nil},
+		{"{\"a\":1}\n{\"token\":2}\n", "{\"a\":1}\n{\"token\":\"[REDACTED]\"}", nil},
+		// truncated bodies are kept up to the last complete token
+		{`{"user": "a", "password": "hun`, `{"user":"a","password":"[REDACTED]"`, io.ErrUnexpectedEOF},
+		{`{"user": "a", "note": "some te`, `{"user":"a","note"`, io.ErrUnexpectedEOF},
+	}
+	for _, tc := range testCases {
+		body, err := redactJSON([]byte(tc.body), patterns)
+		assert.Equal(t, tc.expected, body, tc.body)
+		assert.Equal(t, tc.err, err, tc.body)
+	}
+
+	body, err := redactJSON([]byte(`not json`), patterns)
+	assert.Equal(t, "", body)
+	assert.Error(t, err)
+}
+
+func TestRedactForm(t *testing.T) {
+	patterns := []string{"password", "*token*"}
+	testCases := []struct {
+		body     string
+		expected string
+	}{
+		{"user=a&password=hunter2", "user=a&password=[REDACTED]"},
+		{"user=a&access_token=x&flag", "user=a&access_token=[REDACTED]&flag"},
+		{"pass%77ord=hunter2&note=a+b", "pass%77ord=[REDACTED]&note=a+b"},
+		// a key cut off mid escape can't be checked
+		{"user=a&pass%7=hunt", "user=a&pass%7=[REDACTED]"},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, redactForm(tc.body, patterns), tc.body)
+	}
+}
+
+func TestBodyCaptureRedaction(t *testing.T) {
+	capture := func(contentType, body string) map[string]interface{} {
+		bc := NewBodyCapture(config.HTTPBodyCapture{
+			ContentTypes: []string{"application/*"},
+			RedactKeys:   []string{"password"},
+		})
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		bc.CaptureRequestBody(r)
+		io.ReadAll(r.Body)
+		return bc.Fields()
+	}
+
+	fields := capture("application/x-www-form-urlencoded", "user=a&password=hunter2")
+	assert.Equal(t, "user=a&password=[REDACTED]", fields["request.body"])
+	assert.NotContains(t, fields, "request.body_unparsed")
+
+	fields = capture("application/json", `{"user": "a", "password": hunter2}`)
+	assert.Equal(t, `{"user":"a","password":"[REDACTED]"`, fields["request.body"])
+	assert.Equal(t, true, fields["request.body_unparsed"], "invalid JSON should be flagged")
+	assert.Equal(t, false, fields["request.body_truncated"])
+}
@@ -55,32 +55,68 @@ func StartSpanOrTraceFromHTTP(r *http.Request) (context.Context, *trace.Span) {
 // accepts a TraceParserHook which will be invoked when creating a new trace for the incoming
 // HTTP request.
 func StartSpanOrTraceFromHTTPWithTraceParserHook(r *http.Request, parserHook config.HTTPTraceParserHook) (context.Context, *trace.Span) {
+	return StartSpanOrTraceFromHTTPWithConfig(r, config.HTTPIncomingConfig{HTTPParserHook: parserHook})
+}
+
+// getPropagationContext reads the upstream trace context of r, using
+// parserHook if there is one. Otherwise the globally configured propagator is
+// used, or by default the Honeycomb header, falling back to the W3C headers.
+func getPropagationContext(r *http.Request, parserHook config.HTTPTraceParserHook) *propagation.PropagationContext {
+	if parserHook != nil {
+		// Call the provided TraceParserHook to get the propagation context
+		// from the incoming request. This information will then be used when
+		// create the new trace.
+		return parserHook(r)
+	}
+	var prop *propagation.PropagationContext
+	beelineHeaderValue := r.Header.Get(propagation.TracePropagationHTTPHeader)
+	w3cHeaderValue := r.Header.Get(propagation.TraceparentHeader)
+	if propagation.GlobalConfig.Propagator != nil {
+		// a globally configured propagator replaces the default headers
+		if prop, _ = propagation.GlobalConfig.Propagator.Extract(propagation.HeaderCarrier(r.Header)); prop != nil {
+			return prop
+		}
+	} else if beelineHeaderValue != "" {
+		prop, _ = propagation.UnmarshalHoneycombTraceContext(beelineHeaderValue)
+	} else if w3cHeaderValue != "" {
+		headers := map[string]string{
+			propagation.TraceparentHeader: w3cHeaderValue,
+			propagation.TracestateHeader:  r.Header.Get(propagation.TracestateHeader),
+			propagation.BaggageHeader:     r.Header.Get(propagation.BaggageHeader),
+		}
+		var err error
+		if _, prop, err = propagation.UnmarshalW3CTraceContext(r.Context(), headers); err == nil {
+			// the baggage was parsed along with the trace context
+			return prop
+		}
+	}
+	if baggageHeaderValue := r.Header.Get(propagation.BaggageHeader); baggageHeaderValue != "" {
+		if prop == nil {
+			prop = &propagation.PropagationContext{}
+		}
+		prop.Baggage, _ = propagation.ParseBaggage(baggageHeaderValue)
+	}
+	return prop
+}
+
+// StartSpanOrTraceFromHTTPWithConfig is a version of StartSpanOrTraceFromHTTP
+// that invokes the HTTPParserHook of cfg, if any, when creating a new trace,
+// and adds the request headers selected by cfg.HeaderCapture to the span. If
+// it creates a new trace, the trace only continues the upstream one if
+// cfg.TrustPolicy trusts the request, and if cfg has a SampleRate, the trace
+// is sampled at the rate it returns.
+func StartSpanOrTraceFromHTTPWithConfig(r *http.Request, cfg config.HTTPIncomingConfig) (context.Context, *trace.Span) {
 	ctx := r.Context()
 	span := trace.GetSpanFromContext(ctx)
 	if span == nil {
 		// there is no trace yet. We should make one! and use the root span.
-		var tr *trace.Trace
-		if parserHook == nil {
-			beelineHeaderValue := r.Header.Get(propagation.TracePropagationHTTPHeader)
-			w3cHeaderValue := r.Header.Get(propagation.TraceparentHeader)
-			var prop *propagation.PropagationContext
-			if beelineHeaderValue != "" {
-				prop, _ = propagation.UnmarshalHoneycombTraceContext(beelineHeaderValue)
-			} else if w3cHeaderValue != "" {
-				headers := map[string]string{
-					propagation.TraceparentHeader: w3cHeaderValue,
-				}
-				_, prop, _ = propagation.UnmarshalW3CTraceContext(ctx, headers)
+		prop := getPropagationContext(r, cfg.HTTPParserHook)
+		ctx, span = NewTraceFromUpstream(ctx, prop, TrustsHTTPRequest(r, cfg.TrustPolicy), cfg.TrustPolicy)
+		if cfg.SampleRate != nil {
+			if rate := cfg.SampleRate(r); rate > 0 {
+				span.GetTrace().SetSampleRate(rate)
 			}
-			ctx, tr = trace.NewTrace(ctx, prop)
-		} else {
-			// Call the provided TraceParserHook to get the propagation context
-			// from the incoming request. This information will then be used when
-			// create the new trace.
-			prop := parserHook(r)
-			ctx, tr = trace.NewTrace(ctx, prop)
 		}
-		span = tr.GetRootSpan()
 	} else {
 		// we had a parent! let's make a new child for this handler
 		ctx, span = span.CreateChild(ctx)
@@ -89,9 +125,84 @@ func StartSpanOrTraceFromHTTPWithTraceParserHook(r *http.Request, parserHook con
 	for k, v := range GetRequestProps(r) {
 		span.AddField(k, v)
 	}
+	span.AddFields(GetHeaderProps(r.Header, "request.header.", trustedHeaderCapture(cfg)))
 	return ctx, span
 }
 
+// trustedHeaderCapture returns cfg.HeaderCapture with the header of
+// cfg.TrustPolicy redacted, as its value may be a secret.
+func trustedHeaderCapture(cfg config.HTTPIncomingConfig) config.HTTPHeaderCapture {
+	capture := cfg.HeaderCapture
+	if cfg.TrustPolicy.Header == "" || len(capture.Allow) == 0 {
+		return capture
+	}
+	capture.Redact = append(capture.Redact[:len(capture.Redact):len(capture.Redact)], cfg.TrustPolicy.Header)
+	return capture
+}
+
+// FilterHTTPRequest reports whether r should be traced, according to
+// cfg.Filter. If it shouldn't, it also returns a copy of r whose context
+// carries the upstream trace context of r, if cfg.TrustPolicy trusts it, which
+// the wrapper should pass to the handler in place of r, so that the handler's
+// outgoing calls continue the trace.
+func FilterHTTPRequest(r *http.Request, cfg config.HTTPIncomingConfig) (*http.Request, bool) {
+	if cfg.Filter == nil || cfg.Filter(r) {
+		return r, true
+	}
+	ctx := r.Context()
+	if trace.GetSpanFromContext(ctx) != nil {
+		// the span in the context already carries the trace
+		return r, false
+	}
+	if !TrustsHTTPRequest(r, cfg.TrustPolicy) {
+		// the handler's outgoing calls start new traces
+		return r, false
+	}
+	if prop := getPropagationContext(r, cfg.HTTPParserHook); prop != nil && prop.IsValid() {
+		r = r.WithContext(trace.PutPropagationContextInContext(ctx, prop))
+	}
+	return r, false
+}
+
+// RedactedHeaderValue replaces the value of captured headers that are
+// redacted.
+const RedactedHeaderValue = "[REDACTED]"
+
+// GetHeaderProps returns the headers in h selected by capture as fields, each
+// named prefix followed by the normalized header name. Headers with several
+// values are joined with ", ".
+func GetHeaderProps(h http.Header, prefix string, capture config.HTTPHeaderCapture) map[string]interface{} {
+	props := make(map[string]interface{})
+	if len(capture.Allow) == 0 {
+		return props
+	}
+	allowAll := len(capture.Allow) == 1 && capture.Allow[0] == "*"
+	for name, values := range h {
+		if len(values) == 0 ||
+			(!allowAll && !containsHeader(capture.Allow, name)) ||
+			containsHeader(capture.Deny, name) {
+			continue
+		}
+		value := strings.Join(values, ", ")
+		if containsHeader(capture.Redact, name) ||
+			(!capture.CaptureCredentials && containsHeader(config.DefaultRedactedHeaders, name)) {
+			value = RedactedHeaderValue
+		}
+		props[prefix+strings.ReplaceAll(strings.ToLower(name), "-", "_")] = value
+	}
+	return props
+}
+
+// containsHeader reports whether names includes name, ignoring case.
+func containsHeader(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
 // GetRequestProps is a convenient method to grab all common http request
 // properties and get them back as a map.
 func GetRequestProps(req *http.Request) map[string]interface{} {
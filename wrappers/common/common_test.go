@@ -11,6 +11,7 @@ import (
 
 	"github.com/honeycombio/beeline-go/propagation"
 	"github.com/honeycombio/beeline-go/trace"
+	"github.com/honeycombio/beeline-go/wrappers/config"
 	libhoney "github.com/honeycombio/libhoney-go"
 	"github.com/stretchr/testify/assert"
 )
@@ -79,6 +80,42 @@ func TestXForwardedProtoHeader(t *testing.T) {
 	assert.Equal(t, xForwardedProto, props["request.header.x_forwarded_proto"])
 }
 
+func TestGetHeaderProps(t *testing.T) {
+	h := http.Header{}
+	h.Set("Accept-Language", "en")
+	h.Add("Accept", "text/html")
+	h.Add("Accept", "application/json")
+	h.Set("Authorization", "Bearer secret")
+	h.Set("X-Internal", "1")
+
+	assert.Empty(t, GetHeaderProps(h, "request.header.", config.HTTPHeaderCapture{}), "nothing is captured by default")
+
+	props := GetHeaderProps(h, "request.header.", config.HTTPHeaderCapture{
+		Allow: []string{"accept-language", "Accept", "authorization", "Missing"},
+	})
+	assert.Equal(t, map[string]interface{}{
+		"request.header.accept_language": "en",
+		"request.header.accept":          "text/html, application/json",
+		"request.header.authorization":   RedactedHeaderValue,
+	}, props)
+
+	props = GetHeaderProps(h, "request.header.", config.HTTPHeaderCapture{
+		Allow:  []string{"*"},
+		Redact: []string{"X-Internal"},
+	})
+	assert.Equal(t, RedactedHeaderValue, props["request.header.x_internal"])
+	assert.Equal(t, RedactedHeaderValue, props["request.header.authorization"], "the defaults are redacted as well")
+
+	props = GetHeaderProps(h, "response.header.", config.HTTPHeaderCapture{
+		Allow:              []string{"*"},
+		Deny:               []string{"x-internal"},
+		CaptureCredentials: true,
+	})
+	assert.Equal(t, "Bearer secret", props["response.header.authorization"], "credentials are captured when asked for")
+	assert.NotContains(t, props, "response.header.x_internal")
+	assert.Len(t, props, 3)
+}
+
 // objForDBCalls gives us an object off which to hang the fake database call
 // since the database naming thing looks for actual database calls by name
 // it needs a function named as one of the functions in the `dbNames` list
@@ -223,4 +260,94 @@ func TestStartSpanOrTraceFromHTTP(t *testing.T) {
 		assert.Equal(t, "12345", traceFromContext.GetParentID())
 		assert.Equal(t, "abcdef", traceFromContext.GetTraceID())
 	})
+	t.Run("when w3c tracestate header present, trace carries the tracestate", func(t *testing.T) {
+		u, _ := url.Parse("https://test.com")
+		header := make(http.Header)
+		header.Set(propagation.TraceparentHeader, "00-7f042f75651d9782dcff93a45fa99be0-c998e73e5420f609-01")
+		header.Set(propagation.TracestateHeader, "rojo=00f067aa0ba902b7")
+		req := &http.Request{
+			Method: "GET",
+			URL:    u,
+			Header: header,
+		}
+		ctx, _ := StartSpanOrTraceFromHTTP(req)
+		traceFromContext := trace.GetTraceFromContext(ctx)
+		assert.Equal(t, "00f067aa0ba902b7", traceFromContext.GetTraceStateValue("rojo"))
+	})
+	t.Run("when baggage header present, trace carries the baggage", func(t *testing.T) {
+		u, _ := url.Parse("https://test.com")
+		header := make(http.Header)
+		header.Set(propagation.TracePropagationHTTPHeader, "1;trace_id=abcdef,parent_id=12345")
+		header.Set(propagation.BaggageHeader, "tenant=acme")
+		req := &http.Request{
+			Method: "GET",
+			URL:    u,
+			Header: header,
+		}
+		ctx, _ := StartSpanOrTraceFromHTTP(req)
+		traceFromContext := trace.GetTraceFromContext(ctx)
+		assert.Equal(t, "abcdef", traceFromContext.GetTraceID())
+		assert.Equal(t, "acme", traceFromContext.GetBaggage().Get("tenant"))
+	})
+	t.Run("when w3c and baggage headers present, trace carries the baggage", func(t *testing.T) {
+		u, _ := url.Parse("https://test.com")
+		header := make(http.Header)
+		header.Set(propagation.TraceparentHeader, "00-7f042f75651d9782dcff93a45fa99be0-c998e73e5420f609-01")
+		header.Set(propagation.BaggageHeader, "tenant=acme")
+		req := &http.Request{
+			Method: "GET",
+			URL:    u,
+			Header: header,
+		}
+		ctx, _ := StartSpanOrTraceFromHTTP(req)
+		traceFromContext := trace.GetTraceFromContext(ctx)
+		assert.Equal(t, "7f042f75651d9782dcff93a45fa99be0", traceFromContext.GetTraceID())
+		assert.Equal(t, "acme", traceFromContext.GetBaggage().Get("tenant"))
+	})
+	t.Run("when only the baggage header is present, the new trace carries the baggage", func(t *testing.T) {
+		for name, propagator := range map[string]propagation.Propagator{
+			"default":   nil,
+			"w3c":       propagation.W3CPropagator{},
+			"composite": propagation.NewCompositePropagator(propagation.W3CPropagator{}, propagation.HoneycombPropagator{}),
+		} {
+			propagation.GlobalConfig.Propagator = propagator
+			header := make(http.Header)
+			header.Set(propagation.BaggageHeader, "tenant=acme")
+			req := &http.Request{
+				Method: "GET",
+				URL:    &url.URL{Scheme: "https", Host: "test.com"},
+				Header: header,
+			}
+			ctx, _ := StartSpanOrTraceFromHTTP(req)
+			traceFromContext := trace.GetTraceFromContext(ctx)
+			assert.NotEmpty(t, traceFromContext.GetTraceID(), name)
+			assert.Equal(t, "acme", traceFromContext.GetBaggage().Get("tenant"), name)
+		}
+		propagation.GlobalConfig.Propagator = nil
+	})
+}
+
+func TestFilterHTTPRequest(t *testing.T) {
+	cfg := config.HTTPIncomingConfig{
+		Filter: func(r *http.Request) bool { return r.URL.Path != "/healthz" },
+	}
+	r := httptest.NewRequest("GET", "/users", nil)
+	out, traced := FilterHTTPRequest(r, cfg)
+	assert.True(t, traced)
+	assert.Equal(t, r, out)
+
+	r = httptest.NewRequest("GET", "/healthz", nil)
+	out, traced = FilterHTTPRequest(r, cfg)
+	assert.False(t, traced)
+	assert.Nil(t, trace.GetPropagationContextFromContext(out.Context()), "there is no upstream trace")
+
+	r.Header.Set(propagation.TracePropagationHTTPHeader, "1;trace_id=abcdef,parent_id=12345")
+	out, traced = FilterHTTPRequest(r, cfg)
+	assert.False(t, traced)
+	prop := trace.GetPropagationContextFromContext(out.Context())
+	if assert.NotNil(t, prop) {
+		assert.Equal(t, "abcdef", prop.TraceID)
+		assert.Equal(t, "12345", prop.ParentID)
+	}
+	assert.Nil(t, trace.GetSpanFromContext(out.Context()))
 }
@@ -0,0 +1,107 @@
+package common
+
+import (
+	"net"
+	"path"
+	"strings"
+
+	"github.com/honeycombio/beeline-go/propagation"
+	"github.com/honeycombio/beeline-go/wrappers/config"
+)
+
+// PropagationLevel is how much trace context an outgoing request carries to
+// its destination.
+type PropagationLevel int
+
+const (
+	// PropagateFull sends the full trace context.
+	PropagateFull PropagationLevel = iota
+	// PropagateIDs sends only the trace and parent span IDs.
+	PropagateIDs
+	// PropagateNone sends no trace context.
+	PropagateNone
+)
+
+func (l PropagationLevel) String() string {
+	switch l {
+	case PropagateIDs:
+		return "ids"
+	case PropagateNone:
+		return "none"
+	}
+	return "full"
+}
+
+// DestinationPropagation returns how much trace context policy allows to be
+// sent to the destination host and port. The port may be empty if it isn't
+// known. The host is empty if the destination isn't known at all, and then
+// it gets only what every destination would: nothing if policy could exclude
+// it, and only the IDs if policy trusts some destinations.
+func DestinationPropagation(policy config.DestinationPolicy, host, port string) PropagationLevel {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" {
+		switch {
+		case len(policy.Deny) > 0 || len(policy.Allow) > 0 || policy.Predicate != nil:
+			return PropagateNone
+		case len(policy.Trusted) > 0:
+			return PropagateIDs
+		}
+		return PropagateFull
+	}
+	switch {
+	case matchesDestination(policy.Deny, host, port):
+		return PropagateNone
+	case policy.Predicate != nil && !policy.Predicate(host, port):
+		return PropagateNone
+	case matchesDestination(policy.Trusted, host, port):
+		return PropagateFull
+	case len(policy.Allow) > 0 && !matchesDestination(policy.Allow, host, port):
+		return PropagateNone
+	case len(policy.Trusted) > 0:
+		return PropagateIDs
+	}
+	return PropagateFull
+}
+
+// LimitPropagationContext returns the part of prop that may be sent at level,
+// or nil if none of it may.
+func LimitPropagationContext(prop *propagation.PropagationContext, level PropagationLevel) *propagation.PropagationContext {
+	switch level {
+	case PropagateNone:
+		return nil
+	case PropagateIDs:
+		return &propagation.PropagationContext{
+			TraceID:      prop.TraceID,
+			ParentID:     prop.ParentID,
+			TraceContext: map[string]interface{}{},
+			TraceFlags:   prop.TraceFlags,
+		}
+	}
+	return prop
+}
+
+// matchesDestination reports whether host and port match any of patterns,
+// which are hosts with path.Match wildcards, hosts and ports, or CIDR ranges.
+// host must already be lowercase.
+func matchesDestination(patterns []string, host, port string) bool {
+	ip := net.ParseIP(host)
+	for _, p := range patterns {
+		if strings.Contains(p, "/") {
+			if _, ipNet, err := net.ParseCIDR(p); err == nil && ip != nil && ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		patternHost, patternPort := p, ""
+		if h, pt, err := net.SplitHostPort(p); err == nil {
+			patternHost, patternPort = h, pt
+		}
+		if patternPort != "" && patternPort != port {
+			continue
+		}
+		if ok, _ := path.Match(strings.ToLower(patternHost), host); ok {
+			return true
+		}
+	}
+	return false
+}
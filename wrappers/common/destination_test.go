@@ -0,0 +1,74 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/honeycombio/beeline-go/propagation"
+	"github.com/honeycombio/beeline-go/wrappers/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDestinationPropagation(t *testing.T) {
+	assert.Equal(t, PropagateFull, DestinationPropagation(config.DestinationPolicy{}, "api.stripe.com", "443"),
+		"every destination gets the full context by default")
+
+	policy := config.DestinationPolicy{
+		Allow:   []string{"*.example.com", "10.0.0.0/8", "partner.io:8443"},
+		Deny:    []string{"legacy.example.com"},
+		Trusted: []string{"*.internal.example.com", "10.1.0.0/16"},
+		Predicate: func(host, port string) bool {
+			return host != "blocked.example.com"
+		},
+	}
+	testCases := []struct {
+		host, port string
+		expected   PropagationLevel
+	}{
+		{"svc.internal.example.com", "443", PropagateFull},
+		{"SVC.Internal.Example.com.", "443", PropagateFull},
+		{"10.1.2.3", "80", PropagateFull},
+		{"www.example.com", "443", PropagateIDs},
+		{"10.2.0.1", "80", PropagateIDs},
+		{"partner.io", "8443", PropagateIDs},
+		{"partner.io", "443", PropagateNone},
+		{"legacy.example.com", "443", PropagateNone},
+		{"blocked.example.com", "443", PropagateNone},
+		{"api.stripe.com", "443", PropagateNone},
+		{"192.168.0.1", "80", PropagateNone},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, DestinationPropagation(policy, tc.host, tc.port), tc.host+":"+tc.port)
+	}
+
+	denyOnly := config.DestinationPolicy{Deny: []string{"*.stripe.com"}}
+	assert.Equal(t, PropagateNone, DestinationPropagation(denyOnly, "api.stripe.com", "443"))
+	assert.Equal(t, PropagateFull, DestinationPropagation(denyOnly, "api.internal", "443"),
+		"without a trusted list, allowed destinations get the full context")
+
+	// destinations that aren't known get what every destination would
+	assert.Equal(t, PropagateFull, DestinationPropagation(config.DestinationPolicy{}, "", ""))
+	assert.Equal(t, PropagateNone, DestinationPropagation(denyOnly, "", ""))
+	assert.Equal(t, PropagateNone, DestinationPropagation(policy, "", ""))
+	assert.Equal(t, PropagateIDs, DestinationPropagation(config.DestinationPolicy{Trusted: []string{"*.internal"}}, "", ""))
+}
+
+func TestLimitPropagationContext(t *testing.T) {
+	baggage, _ := propagation.ParseBaggage("tenant=acme")
+	prop := &propagation.PropagationContext{
+		TraceID:      "abcdef",
+		ParentID:     "0102",
+		Dataset:      "secret-dataset",
+		TraceContext: map[string]interface{}{"user.email": "a@example.com"},
+		Baggage:      baggage,
+	}
+	assert.Equal(t, prop, LimitPropagationContext(prop, PropagateFull))
+	assert.Nil(t, LimitPropagationContext(prop, PropagateNone))
+
+	limited := LimitPropagationContext(prop, PropagateIDs)
+	assert.Equal(t, "abcdef", limited.TraceID)
+	assert.Equal(t, "0102", limited.ParentID)
+	assert.Empty(t, limited.Dataset)
+	assert.Empty(t, limited.TraceContext)
+	assert.Equal(t, 0, limited.Baggage.Len())
+	assert.Equal(t, "1;trace_id=abcdef,parent_id=0102,context=e30=", propagation.MarshalHoneycombTraceContext(limited))
+}
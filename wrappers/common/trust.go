@@ -0,0 +1,93 @@
+package common
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/honeycombio/beeline-go/propagation"
+	"github.com/honeycombio/beeline-go/trace"
+	"github.com/honeycombio/beeline-go/wrappers/config"
+)
+
+// TrustsClient reports whether policy trusts the trace context sent by a
+// client connected from remoteAddr, which may include a port, whose request
+// has the header values returned by header. verified is whether the client
+// presented a TLS certificate the server verified.
+func TrustsClient(policy config.TrustPolicy, remoteAddr string, header func(name string) string, verified bool) bool {
+	if len(policy.Networks) == 0 && policy.Header == "" && !policy.ClientCertificate {
+		return true
+	}
+	if policy.ClientCertificate && verified {
+		return true
+	}
+	if policy.Header != "" {
+		value := header(policy.Header)
+		if value != "" && (policy.HeaderValue == "" ||
+			subtle.ConstantTimeCompare([]byte(value), []byte(policy.HeaderValue)) == 1) {
+			return true
+		}
+	}
+	host := remoteAddr
+	if h, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		host = h
+	}
+	return host != "" && matchesDestination(policy.Networks, strings.ToLower(host), "")
+}
+
+// TrustsHTTPRequest reports whether policy trusts the trace context of r.
+func TrustsHTTPRequest(r *http.Request, policy config.TrustPolicy) bool {
+	verified := r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+	return TrustsClient(policy, r.RemoteAddr, r.Header.Get, verified)
+}
+
+// NewTraceFromUpstream starts a new trace for a request that carried the
+// upstream trace context prop, which may be nil, and returns its root span.
+// If the request is trusted the trace continues the upstream one. If not, it
+// gets new IDs and only the trace level fields policy allows, and the upstream
+// trace and span IDs are added to the root span as a link.
+func NewTraceFromUpstream(ctx context.Context, prop *propagation.PropagationContext, trusted bool, policy config.TrustPolicy) (context.Context, *trace.Span) {
+	if trusted {
+		ctx, tr := trace.NewTrace(ctx, prop)
+		return ctx, tr.GetRootSpan()
+	}
+	ctx, tr := trace.NewTrace(ctx, untrustedPropagationContext(prop, policy.UntrustedFieldMaxBytes))
+	span := tr.GetRootSpan()
+	if prop != nil && prop.IsValid() {
+		span.AddLink(prop.TraceID, prop.ParentID, nil)
+		span.AddField("meta.untrusted_upstream", true)
+	}
+	return ctx, span
+}
+
+// untrustedPropagationContext returns the trace level fields of prop that fit
+// in maxBytes, or nil if none do.
+func untrustedPropagationContext(prop *propagation.PropagationContext, maxBytes int) *propagation.PropagationContext {
+	if prop == nil || maxBytes <= 0 || len(prop.TraceContext) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(prop.TraceContext))
+	for k := range prop.TraceContext {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fields := make(map[string]interface{})
+	for _, k := range keys {
+		value, err := json.Marshal(prop.TraceContext[k])
+		if err != nil {
+			continue
+		}
+		if maxBytes -= len(k) + len(value); maxBytes < 0 {
+			break
+		}
+		fields[k] = prop.TraceContext[k]
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return &propagation.PropagationContext{TraceContext: fields}
+}
@@ -0,0 +1,77 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/honeycombio/beeline-go/propagation"
+	"github.com/honeycombio/beeline-go/wrappers/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrustsHTTPRequest(t *testing.T) {
+	policy := config.TrustPolicy{
+		Networks:          []string{"10.0.0.0/8", "::1"},
+		Header:            "X-Internal-Token",
+		HeaderValue:       "s3cret",
+		ClientCertificate: true,
+	}
+	testCases := []struct {
+		name     string
+		addr     string
+		token    string
+		verified bool
+		expected bool
+	}{
+		{"internal network", "10.1.2.3:5000", "", false, true},
+		{"internal address", "[::1]:5000", "", false, true},
+		{"external", "203.0.113.7:5000", "", false, false},
+		{"header", "203.0.113.7:5000", "s3cret", false, true},
+		{"wrong header", "203.0.113.7:5000", "guess", false, false},
+		{"client certificate", "203.0.113.7:5000", "", true, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.addr
+			if tc.token != "" {
+				r.Header.Set("X-Internal-Token", tc.token)
+			}
+			if tc.verified {
+				r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+			}
+			assert.Equal(t, tc.expected, TrustsHTTPRequest(r, policy))
+			assert.True(t, TrustsHTTPRequest(r, config.TrustPolicy{}), "every request is trusted by default")
+		})
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-From-Proxy", "1")
+	assert.True(t, TrustsHTTPRequest(r, config.TrustPolicy{Header: "X-From-Proxy"}), "any value will do without a HeaderValue")
+}
+
+func TestNewTraceFromUpstream(t *testing.T) {
+	prop := &propagation.PropagationContext{
+		TraceID:      "abcdef",
+		ParentID:     "12345",
+		Dataset:      "theirs",
+		TraceContext: map[string]interface{}{"a": "1", "b": "22", "c": "333"},
+	}
+
+	ctx, span := NewTraceFromUpstream(context.Background(), prop, true, config.TrustPolicy{})
+	assert.NotNil(t, ctx)
+	assert.Equal(t, "abcdef", span.GetTrace().GetTraceID())
+	assert.Equal(t, "12345", span.GetTrace().GetParentID())
+
+	_, span = NewTraceFromUpstream(context.Background(), prop, false, config.TrustPolicy{})
+	assert.NotEqual(t, "abcdef", span.GetTrace().GetTraceID(), "an untrusted request starts a new trace")
+	assert.Equal(t, "", span.GetTrace().GetParentID())
+
+	assert.Nil(t, untrustedPropagationContext(prop, 0), "untrusted fields are dropped by default")
+	// each field takes the length of its key and its JSON encoded value
+	assert.Equal(t, map[string]interface{}{"a": "1", "b": "22"}, untrustedPropagationContext(prop, 10).TraceContext)
+	assert.Nil(t, untrustedPropagationContext(prop, 3))
+}
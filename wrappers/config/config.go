@@ -3,6 +3,7 @@ package config
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/honeycombio/beeline-go/propagation"
 	"google.golang.org/grpc/metadata"
@@ -33,14 +34,184 @@ type HTTPTracePropagationHook func(*http.Request, *propagation.PropagationContex
 // a wrapper.
 type HTTPIncomingConfig struct {
 	HTTPParserHook HTTPTraceParserHook
+	// HeaderCapture selects request and response headers to add to the span,
+	// in addition to the few that are always captured.
+	HeaderCapture HTTPHeaderCapture
+	// BodyCapture selects request and response bodies to add to the span.
+	BodyCapture HTTPBodyCapture
+	// Filter is called for each request, and if it returns false, no span is
+	// created for the request. Its upstream trace context is still put in
+	// the request context, so that calls the handler makes to other services
+	// continue the trace. Use it to leave out health checks and other noisy
+	// requests.
+	Filter func(*http.Request) bool
+	// SampleRate is called for each request that starts a new trace, and if
+	// it returns a rate other than 0, the trace is sampled at that rate
+	// instead of the configured one. See trace.Trace.SetSampleRate.
+	SampleRate func(*http.Request) uint
+	// TrustPolicy selects the requests whose upstream trace context is
+	// trusted. By default every request is.
+	TrustPolicy TrustPolicy
 }
 
 // HTTPOutgoingConfig stores configuration options relevant to HTTP requests being sent by an
 // instrumented application.
 type HTTPOutgoingConfig struct {
 	HTTPPropagationHook HTTPTracePropagationHook
+	// HeaderCapture selects request and response headers to add to the client
+	// span.
+	HeaderCapture HTTPHeaderCapture
+	// ClientTrace selects how the timings of the phases of each request, such
+	// as DNS lookup, connecting and waiting for the response, are recorded.
+	// By default they aren't.
+	ClientTrace HTTPClientTraceMode
+	// TraceResponseBody keeps the client span open until the response body
+	// has been read to EOF or closed, rather than sending it as soon as the
+	// response headers arrive. The span then records the number of body bytes
+	// read, how long reading took, and any read error.
+	TraceResponseBody bool
+	// ResponseBodyTimeout is how long the span is kept waiting for a response
+	// body that is never read to EOF or closed. Defaults to five minutes.
+	ResponseBodyTimeout time.Duration
+	// BodyCapture selects request and response bodies to add to the client
+	// span. Requests whose response body is captured keep their span open
+	// until the body is done with, as if TraceResponseBody were set.
+	BodyCapture HTTPBodyCapture
+	// DestinationPolicy selects the hosts that are sent trace propagation
+	// headers. By default every host is sent the full trace context.
+	DestinationPolicy DestinationPolicy
 }
 
+// DefaultRedactedHeaders are the headers whose values are always redacted,
+// unless HTTPHeaderCapture.CaptureCredentials is set.
+var DefaultRedactedHeaders = []string{"Authorization", "Cookie", "Set-Cookie"}
+
+// HTTPHeaderCapture selects the HTTP headers added to spans as fields. Request
+// headers are added as `request.header.<name>` and response headers as
+// `response.header.<name>`, with the name lowercased and dashes replaced by
+// underscores, eg `request.header.accept_language`. Header names are matched
+// case insensitively.
+type HTTPHeaderCapture struct {
+	// Allow lists the headers to capture. The single entry "*" captures all
+	// headers.
+	Allow []string
+	// Deny lists headers that are never captured, even when allowed.
+	Deny []string
+	// Redact lists headers that are captured with their value replaced, so
+	// that it is visible they were sent but not what they held. They are
+	// redacted in addition to DefaultRedactedHeaders.
+	Redact []string
+	// CaptureCredentials captures the values of DefaultRedactedHeaders,
+	// such as Authorization and Cookie, as they are. Only the headers in
+	// Redact are redacted then.
+	CaptureCredentials bool
+}
+
+// HTTPBodyCapture selects the HTTP bodies added to spans as the
+// `request.body` and `response.body` fields. Bodies are copied as they are
+// streamed, so no more than MaxBytes of each is held in memory; when a body
+// is longer, the captured prefix is kept and `request.body_truncated` or
+// `response.body_truncated` is set.
+type HTTPBodyCapture struct {
+	// ContentTypes lists the media types of the bodies to capture, such as
+	// "application/json". A subtype of "*" matches any subtype, so
+	// "text/*" captures all text bodies. Bodies are only captured if this is
+	// set.
+	ContentTypes []string
+	// MaxBytes is the most bytes captured from each body. Defaults to 4096.
+	MaxBytes int
+	// SampleRate captures the bodies of one in SampleRate requests, chosen
+	// independently of the trace sampler. A value of 0 or 1 captures the
+	// bodies of every request.
+	SampleRate uint
+	// RedactKeys lists patterns, in the syntax of path.Match, for the keys
+	// of JSON objects and URL encoded forms whose values are replaced before
+	// bodies are added to the span, eg "password" or "*token*". Keys are
+	// matched case insensitively, at any depth in JSON. A JSON body that
+	// can't be parsed is only added up to where parsing failed, and
+	// `request.body_unparsed` or `response.body_unparsed` is set.
+	RedactKeys []string
+}
+
+// DestinationPolicy decides how much trace context outgoing requests carry
+// to each destination, so that trace level fields, which may be sensitive,
+// aren't sent to third parties. Requests to every destination are still
+// recorded in a client span.
+//
+// Destinations are given as a host, matched with the wildcards of path.Match
+// such as "*.internal.example.com", as a host and port such as
+// "payments.example.com:443", or as a CIDR range such as "10.0.0.0/8", which
+// matches hosts given as IP addresses. Hosts are matched case insensitively.
+//
+// A destination gets no trace context if it is denied, if Predicate returns
+// false for it, or if Allow is set and it is neither allowed nor trusted.
+// Otherwise it gets the full trace context if Trusted is unset or lists it,
+// and only the trace and parent span IDs if not. The zero DestinationPolicy
+// sends every destination the full trace context.
+type DestinationPolicy struct {
+	// Allow lists the destinations that are sent trace context. If unset,
+	// every destination that isn't denied is.
+	Allow []string
+	// Deny lists destinations that are never sent trace context.
+	Deny []string
+	// Trusted lists the destinations sent the full trace context, including
+	// trace level fields, the dataset, baggage and tracestate. Other allowed
+	// destinations only get the trace and parent span IDs.
+	Trusted []string
+	// Predicate, if set, is called with the host and port of each
+	// destination that isn't denied, and must also return true for the
+	// destination to be sent trace context.
+	Predicate func(host, port string) bool
+}
+
+// TrustPolicy decides whose incoming trace context is trusted. A trusted
+// request continues the trace it came from, keeping its trace and parent span
+// IDs and trace level fields. An untrusted request, such as one from the
+// internet, which could carry any trace IDs and fields it liked, starts a new
+// trace instead, and the trace and span IDs it carried are kept only as a
+// link on the new root span.
+//
+// A request is trusted if it matches any of Networks, Header or
+// ClientCertificate. The zero TrustPolicy trusts every request.
+type TrustPolicy struct {
+	// Networks lists the CIDR ranges, such as "10.0.0.0/8", or single IP
+	// addresses of trusted clients. They are matched against the address of
+	// the connection, not any X-Forwarded-For header.
+	Networks []string
+	// Header names a header, or gRPC metadata key, that marks a request as
+	// trusted when its value is HeaderValue, eg a shared secret added by an
+	// internal proxy. If HeaderValue is empty, any value will do, which is
+	// only safe if the proxy removes the header from outside requests. The
+	// header is redacted if headers are captured.
+	Header      string
+	HeaderValue string
+	// ClientCertificate trusts clients that presented a TLS certificate the
+	// server verified, as with mutual TLS.
+	ClientCertificate bool
+	// UntrustedFieldMaxBytes is how much of the trace level fields of
+	// untrusted requests is kept, as the total size of their JSON encoded
+	// keys and values. Fields are kept in order of their keys until the
+	// next one would exceed it. By default they are all dropped, along with
+	// the dataset, baggage and tracestate of untrusted requests.
+	UntrustedFieldMaxBytes int
+}
+
+// HTTPClientTraceMode selects how an instrumented HTTP client records the
+// phases of a request, as reported by net/http/httptrace.
+type HTTPClientTraceMode int
+
+const (
+	// HTTPClientTraceOff records no phase timings.
+	HTTPClientTraceOff HTTPClientTraceMode = iota
+	// HTTPClientTraceFields adds the duration of each phase and details of
+	// the connection used as fields on the client span.
+	HTTPClientTraceFields
+	// HTTPClientTraceEvents adds the fields, and a span event for each phase.
+	HTTPClientTraceEvents
+	// HTTPClientTraceSpans adds the fields, and a child span for each phase.
+	HTTPClientTraceSpans
+)
+
 // GRPCTraceParserHook is a function that will be invoked on all incoming gRPC requests
 // when it is passed as a parameter to an interceptor wrapper function such as the one
 // provided in the hnygrpc package. It can be used to create a PropagationContext object
@@ -59,10 +230,34 @@ type GRPCTracePropagationHook func(*propagation.PropagationContext) metadata.MD
 // handled by a wrapped gRPC interceptor provided in the hnygrpc package.
 type GRPCIncomingConfig struct {
 	GRPCParserHook GRPCTraceParserHook
+	// Filter is called with the full method name of each RPC, eg
+	// "/grpc.health.v1.Health/Check", and if it returns false, no span is
+	// created for the RPC. Its upstream trace context is still put in the
+	// context passed to the handler, so that calls the handler makes to other
+	// services continue the trace.
+	Filter func(fullMethod string) bool
+	// TrustPolicy selects the RPCs whose upstream trace context is trusted.
+	// By default every RPC is.
+	TrustPolicy TrustPolicy
+	// MessageEvents adds a span event for each message sent or received on a
+	// streaming RPC. It is off by default, as long lived streams can carry a
+	// very large number of messages.
+	MessageEvents bool
 }
 
 // GRPCOutgoingConfig stores configuration options relevant to gRPC requests being sent
 // by an instrumented application.
 type GRPCOutgoingConfig struct {
 	GRPCPropagationHook GRPCTracePropagationHook
+	// MessageEvents adds a span event for each message sent or received on
+	// client streams, as GRPCIncomingConfig.MessageEvents does for servers.
+	MessageEvents bool
+	// DestinationPolicy selects the targets that are sent trace context in
+	// the gRPC metadata. The target of each RPC is that of its ClientConn,
+	// eg "api.internal:443". By default every target is sent the full trace
+	// context. Stats handlers aren't told the target of an RPC, so unless
+	// the policy is the zero value, they send no trace context if it has
+	// Allow, Deny or a Predicate, and only the IDs otherwise; install the
+	// client interceptors to apply it to each target.
+	DestinationPolicy DestinationPolicy
 }
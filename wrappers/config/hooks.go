@@ -0,0 +1,112 @@
+package config
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/honeycombio/beeline-go/propagation"
+	"google.golang.org/grpc/metadata"
+)
+
+// This file contains ready-made parser and propagation hooks for the trace
+// header formats supported by the propagation package that the wrappers do
+// not speak by default.
+
+// headersFromHTTP flattens HTTP headers into the map with lowercase header
+// names expected by the unmarshal functions in the propagation package.
+func headersFromHTTP(h http.Header) map[string]string {
+	headers := make(map[string]string, len(h))
+	for k := range h {
+		headers[strings.ToLower(k)] = h.Get(k)
+	}
+	return headers
+}
+
+// headersFromMetadata flattens gRPC metadata, whose keys are already
+// lowercase, into a map for the unmarshal functions in the propagation package.
+func headersFromMetadata(md metadata.MD) map[string]string {
+	headers := make(map[string]string, len(md))
+	for k, v := range md {
+		if len(v) > 0 {
+			headers[k] = v[0]
+		}
+	}
+	return headers
+}
+
+// JaegerHTTPParserHook is an HTTPTraceParserHook that continues traces from
+// Jaeger uber-trace-id and uberctx- headers.
+func JaegerHTTPParserHook(r *http.Request) *propagation.PropagationContext {
+	_, prop, err := propagation.UnmarshalJaegerTraceContext(r.Context(), headersFromHTTP(r.Header))
+	if err != nil {
+		return nil
+	}
+	return prop
+}
+
+// JaegerHTTPPropagationHook is an HTTPTracePropagationHook that sends Jaeger
+// uber-trace-id and uberctx- headers.
+func JaegerHTTPPropagationHook(r *http.Request, prop *propagation.PropagationContext) map[string]string {
+	_, headers := propagation.MarshalJaegerTraceContext(r.Context(), prop)
+	return headers
+}
+
+// JaegerGRPCParserHook is a GRPCTraceParserHook that continues traces from
+// Jaeger uber-trace-id and uberctx- metadata.
+func JaegerGRPCParserHook(ctx context.Context) *propagation.PropagationContext {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	_, prop, err := propagation.UnmarshalJaegerTraceContext(ctx, headersFromMetadata(md))
+	if err != nil {
+		return nil
+	}
+	return prop
+}
+
+// JaegerGRPCPropagationHook is a GRPCTracePropagationHook that sends Jaeger
+// uber-trace-id and uberctx- metadata.
+func JaegerGRPCPropagationHook(prop *propagation.PropagationContext) metadata.MD {
+	_, headers := propagation.MarshalJaegerTraceContext(context.Background(), prop)
+	return metadata.New(headers)
+}
+
+// DatadogHTTPParserHook is an HTTPTraceParserHook that continues traces from
+// Datadog x-datadog-* headers.
+func DatadogHTTPParserHook(r *http.Request) *propagation.PropagationContext {
+	_, prop, err := propagation.UnmarshalDatadogTraceContext(r.Context(), headersFromHTTP(r.Header))
+	if err != nil {
+		return nil
+	}
+	return prop
+}
+
+// DatadogHTTPPropagationHook is an HTTPTracePropagationHook that sends Datadog
+// x-datadog-* headers.
+func DatadogHTTPPropagationHook(r *http.Request, prop *propagation.PropagationContext) map[string]string {
+	_, headers := propagation.MarshalDatadogTraceContext(r.Context(), prop)
+	return headers
+}
+
+// DatadogGRPCParserHook is a GRPCTraceParserHook that continues traces from
+// Datadog x-datadog-* metadata.
+func DatadogGRPCParserHook(ctx context.Context) *propagation.PropagationContext {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	_, prop, err := propagation.UnmarshalDatadogTraceContext(ctx, headersFromMetadata(md))
+	if err != nil {
+		return nil
+	}
+	return prop
+}
+
+// DatadogGRPCPropagationHook is a GRPCTracePropagationHook that sends Datadog
+// x-datadog-* metadata.
+func DatadogGRPCPropagationHook(prop *propagation.PropagationContext) metadata.MD {
+	_, headers := propagation.MarshalDatadogTraceContext(context.Background(), prop)
+	return metadata.New(headers)
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/honeycombio/beeline-go/propagation"
+)
+
+func TestJaegerHooks(t *testing.T) {
+	r, _ := http.NewRequest("GET", "http://example.com", nil)
+	r.Header.Set("Uber-Trace-Id", "0af7651916cd43dd8448eb211c80319c:b7ad6b7169203331:0:1")
+	r.Header.Set("Uberctx-Tenant", "acme")
+	prop := JaegerHTTPParserHook(r)
+	if assert.NotNil(t, prop) {
+		assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", prop.TraceID)
+		assert.Equal(t, "b7ad6b7169203331", prop.ParentID)
+		assert.Equal(t, "acme", prop.TraceContext["tenant"])
+	}
+
+	headers := JaegerHTTPPropagationHook(r, prop)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c:b7ad6b7169203331:0:1", headers[propagation.JaegerTraceHeader])
+
+	md := JaegerGRPCPropagationHook(prop)
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	assert.Equal(t, prop, JaegerGRPCParserHook(ctx))
+
+	r.Header.Del("Uber-Trace-Id")
+	assert.Nil(t, JaegerHTTPParserHook(r))
+	assert.Nil(t, JaegerGRPCParserHook(context.Background()))
+}
+
+func TestDatadogHooks(t *testing.T) {
+	r, _ := http.NewRequest("GET", "http://example.com", nil)
+	r.Header.Set("X-Datadog-Trace-Id", "9532127138774266268")
+	r.Header.Set("X-Datadog-Parent-Id", "13235353014750950193")
+	r.Header.Set("X-Datadog-Sampling-Priority", "1")
+	r.Header.Set("X-Datadog-Tags", "_dd.p.tid=640cfd8d00000000")
+	prop := DatadogHTTPParserHook(r)
+	if assert.NotNil(t, prop) {
+		assert.Equal(t, "640cfd8d000000008448eb211c80319c", prop.TraceID)
+		assert.Equal(t, "b7ad6b7169203331", prop.ParentID)
+		assert.True(t, prop.TraceFlags.IsSampled())
+	}
+
+	headers := DatadogHTTPPropagationHook(r, prop)
+	assert.Equal(t, "9532127138774266268", headers[propagation.DatadogTraceIDHeader])
+
+	md := DatadogGRPCPropagationHook(prop)
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+	assert.Equal(t, prop, DatadogGRPCParserHook(ctx))
+
+	r.Header.Del("X-Datadog-Trace-Id")
+	assert.Nil(t, DatadogHTTPParserHook(r))
+}
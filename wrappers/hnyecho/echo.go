@@ -4,6 +4,7 @@ import (
 	"sync"
 
 	"github.com/honeycombio/beeline-go/wrappers/common"
+	"github.com/honeycombio/beeline-go/wrappers/config"
 	"github.com/labstack/echo/v4"
 )
 
@@ -12,6 +13,7 @@ type (
 	EchoWrapper struct {
 		handlerNames map[string]string
 		once         sync.Once
+		cfg          config.HTTPIncomingConfig
 	}
 )
 
@@ -20,16 +22,33 @@ func New() *EchoWrapper {
 	return &EchoWrapper{}
 }
 
+// NewWithConfig returns a new EchoWrapper struct whose middleware uses the
+// parser hook, header and body capture, filter and sample rate settings of
+// cfg.
+func NewWithConfig(cfg config.HTTPIncomingConfig) *EchoWrapper {
+	return &EchoWrapper{cfg: cfg}
+}
+
 // Middleware returns an echo.MiddlewareFunc to be used with Echo.Use()
 func (e *EchoWrapper) Middleware() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			r := c.Request()
+			// requests the filter drops are passed on untraced
+			if untraced, traced := common.FilterHTTPRequest(r, e.cfg); !traced {
+				c.SetRequest(untraced)
+				return next(c)
+			}
 			// get a new context with our trace from the request
-			ctx, span := common.StartSpanOrTraceFromHTTP(r)
+			ctx, span := common.StartSpanOrTraceFromHTTPWithConfig(r, e.cfg)
 			defer span.Send()
 			// push the context with our trace and span on to the request
-			c.SetRequest(r.WithContext(ctx))
+			r = r.WithContext(ctx)
+			// record the bodies, if they are captured
+			bodyCapture := common.NewBodyCapture(e.cfg.BodyCapture)
+			bodyCapture.CaptureRequestBody(r)
+			c.SetRequest(r)
+			c.Response().Writer = bodyCapture.WrapResponseWriter(c.Response().Writer)
 
 			// get name of handler
 			handlerName := e.handlerName(c)
@@ -58,6 +77,8 @@ func (e *EchoWrapper) Middleware() echo.MiddlewareFunc {
 			// add fields for http response code and size
 			span.AddField("response.status_code", c.Response().Status)
 			span.AddField("response.size", c.Response().Size)
+			span.AddFields(common.GetHeaderProps(c.Response().Header(), "response.header.", e.cfg.HeaderCapture))
+			span.AddFields(bodyCapture.Fields())
 
 			return nil
 		}
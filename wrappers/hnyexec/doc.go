@@ -0,0 +1,18 @@
+// Package hnyexec wraps `os/exec` commands so that running a child process
+// creates a span, and the child process can continue the trace.
+//
+// Usage
+//
+// Create commands with CommandContext instead of exec.CommandContext, or wrap
+// an existing *exec.Cmd, and run them as usual:
+//
+//     cmd := hnyexec.CommandContext(r.Context(), "convert", in, out)
+//     if err := cmd.Run(); err != nil {
+//         ...
+//     }
+//
+// Each run sends a span with the command name, process ID, exit code and, if
+// the process was killed, the signal. The trace context is passed to the child
+// process in its environment; programs using the beeline pick it up with
+// beeline.StartSpanFromEnv.
+package hnyexec
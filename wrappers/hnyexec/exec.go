@@ -0,0 +1,117 @@
+package hnyexec
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+
+	beeline "github.com/honeycombio/beeline-go"
+	"github.com/honeycombio/beeline-go/trace"
+)
+
+// Cmd is an *exec.Cmd that sends a span for each run of the command. All the
+// exec.Cmd fields and methods are available; the methods that run the command
+// (Run, Start, Wait, Output and CombinedOutput) are instrumented.
+type Cmd struct {
+	*exec.Cmd
+
+	ctx  context.Context
+	span *trace.Span
+}
+
+// CommandContext returns a Cmd to run the named program with the given
+// arguments, as exec.CommandContext does. The span for the command is a child
+// of the span in ctx, and the command is killed if ctx is done before it
+// finishes.
+func CommandContext(ctx context.Context, name string, arg ...string) *Cmd {
+	return Wrap(ctx, exec.CommandContext(ctx, name, arg...))
+}
+
+// Wrap returns a Cmd that runs cmd. The span for the command is a child of the
+// span in ctx.
+func Wrap(ctx context.Context, cmd *exec.Cmd) *Cmd {
+	return &Cmd{
+		Cmd: cmd,
+		ctx: ctx,
+	}
+}
+
+// Start starts the command, beginning its span. Wait must be called to finish
+// the span.
+func (c *Cmd) Start() error {
+	c.startSpan()
+	err := c.Cmd.Start()
+	if err != nil {
+		c.finishSpan(err)
+	}
+	return err
+}
+
+// Wait waits for the command started with Start to exit, and sends its span.
+func (c *Cmd) Wait() error {
+	err := c.Cmd.Wait()
+	c.finishSpan(err)
+	return err
+}
+
+// Run starts the command and waits for it to exit.
+func (c *Cmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Output runs the command and returns its standard output.
+func (c *Cmd) Output() ([]byte, error) {
+	c.startSpan()
+	out, err := c.Cmd.Output()
+	c.finishSpan(err)
+	return out, err
+}
+
+// CombinedOutput runs the command and returns its combined standard output
+// and standard error.
+func (c *Cmd) CombinedOutput() ([]byte, error) {
+	c.startSpan()
+	out, err := c.Cmd.CombinedOutput()
+	c.finishSpan(err)
+	return out, err
+}
+
+// startSpan creates the span for a run of the command and passes its trace
+// context to the child process.
+func (c *Cmd) startSpan() {
+	ctx, span := beeline.StartSpan(c.ctx, "exec")
+	span.AddField("meta.type", "exec")
+	span.AddField("exec.name", filepath.Base(c.Path))
+	span.AddField("exec.path", c.Path)
+	if c.Dir != "" {
+		span.AddField("exec.dir", c.Dir)
+	}
+	beeline.InjectEnv(ctx, c.Cmd)
+	c.span = span
+}
+
+// finishSpan records how the command exited and sends its span.
+func (c *Cmd) finishSpan(err error) {
+	span := c.span
+	if span == nil {
+		return
+	}
+	c.span = nil
+	if c.Process != nil {
+		span.AddField("exec.pid", c.Process.Pid)
+	}
+	if ps := c.ProcessState; ps != nil {
+		// the exit code is -1 if the process was killed by a signal
+		span.AddField("exec.exit_code", ps.ExitCode())
+		if sig := processSignal(ps); sig != "" {
+			span.AddField("exec.signal", sig)
+		}
+	}
+	if err != nil {
+		span.AddField("error", err.Error())
+	}
+	span.Send()
+}
@@ -0,0 +1,77 @@
+package hnyexec
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	beeline "github.com/honeycombio/beeline-go"
+	libhoney "github.com/honeycombio/libhoney-go"
+	"github.com/honeycombio/libhoney-go/transmission"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestHelperProcess is not a real test. It is run as the child process by the
+// other tests, and prints the trace context it was given before exiting with
+// the requested code.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	fmt.Print(os.Getenv("TRACEPARENT"))
+	code := 0
+	fmt.Sscan(os.Getenv("HELPER_EXIT_CODE"), &code)
+	os.Exit(code)
+}
+
+func helperCommand(ctx context.Context, exitCode int) *Cmd {
+	cmd := CommandContext(ctx, os.Args[0], "-test.run=TestHelperProcess")
+	cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1", fmt.Sprintf("HELPER_EXIT_CODE=%d", exitCode))
+	return cmd
+}
+
+func TestCmd(t *testing.T) {
+	mo := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:       "placeholder",
+		Dataset:      "placeholder",
+		APIHost:      "placeholder",
+		Transmission: mo})
+	assert.Equal(t, nil, err)
+	beeline.Init(beeline.Config{Client: client})
+
+	ctx, parent := beeline.StartSpan(context.Background(), "parent")
+	out, err := helperCommand(ctx, 0).Output()
+	assert.NoError(t, err)
+	err = helperCommand(ctx, 3).Run()
+	var exitErr *exec.ExitError
+	assert.ErrorAs(t, err, &exitErr)
+	parent.Send()
+
+	evs := mo.Events()
+	assert.Equal(t, 3, len(evs))
+	ok := evs[0].Data
+	assert.Equal(t, "exec", ok["name"])
+	assert.Equal(t, parent.GetSpanID(), ok["trace.parent_id"])
+	assert.NotEmpty(t, ok["exec.name"])
+	assert.NotEmpty(t, ok["exec.pid"])
+	assert.Equal(t, 0, ok["exec.exit_code"])
+	assert.NotContains(t, ok, "error")
+	assert.True(t, strings.HasPrefix(string(out), "00-"+parent.GetTrace().GetTraceID()+"-"), "child should get the trace context")
+	assert.Contains(t, string(out), ok["trace.span_id"], "child should get the exec span as its parent")
+
+	failed := evs[1].Data
+	assert.Equal(t, 3, failed["exec.exit_code"])
+	assert.Equal(t, "exit status 3", failed["error"])
+
+	// a command that can't start still sends a span
+	err = CommandContext(ctx, "hnyexec-does-not-exist").Start()
+	assert.Error(t, err)
+	evs = mo.Events()
+	assert.Equal(t, 4, len(evs))
+	assert.Equal(t, err.Error(), evs[3].Data["error"])
+	assert.NotContains(t, evs[3].Data, "exec.exit_code")
+}
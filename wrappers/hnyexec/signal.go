@@ -0,0 +1,17 @@
+//go:build !plan9
+
+package hnyexec
+
+import (
+	"os"
+	"syscall"
+)
+
+// processSignal returns the name of the signal that killed the process, or an
+// empty string if it exited normally.
+func processSignal(ps *os.ProcessState) string {
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return ws.Signal().String()
+	}
+	return ""
+}
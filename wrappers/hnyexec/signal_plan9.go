@@ -0,0 +1,9 @@
+package hnyexec
+
+import "os"
+
+// processSignal returns an empty string, as plan9 processes are stopped with
+// notes rather than signals.
+func processSignal(ps *os.ProcessState) string {
+	return ""
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/honeycombio/beeline-go"
 	"github.com/honeycombio/beeline-go/trace"
 	"github.com/honeycombio/beeline-go/wrappers/common"
+	"github.com/honeycombio/beeline-go/wrappers/config"
 )
 
 const ginContextKey = "beeline-middleware-context"
@@ -14,15 +15,34 @@ const ginContextKey = "beeline-middleware-context"
 // Middleware wraps httprouter handlers. Since it wraps handlers with explicit
 // parameters, it can add those values to the event it generates.
 func Middleware(queryParams map[string]struct{}) gin.HandlerFunc {
+	return MiddlewareWithConfig(queryParams, config.HTTPIncomingConfig{})
+}
+
+// MiddlewareWithConfig is a version of Middleware that uses the parser hook,
+// header and body capture, filter and sample rate settings of cfg.
+func MiddlewareWithConfig(queryParams map[string]struct{}, cfg config.HTTPIncomingConfig) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		// requests the filter drops are passed on untraced
+		if untraced, traced := common.FilterHTTPRequest(c.Request, cfg); !traced {
+			c.Set(ginContextKey, untraced.Context())
+			c.Request = untraced
+			c.Next()
+			return
+		}
 		// get a new context with our trace from the request, and add common fields
-		ctx, span := common.StartSpanOrTraceFromHTTP(c.Request)
+		ctx, span := common.StartSpanOrTraceFromHTTPWithConfig(c.Request, cfg)
 		defer span.Send()
 		// Add the span context to the gin context as we need to be able to pass
 		// this context around our gin application
 		c.Set(ginContextKey, ctx)
 		// push the context with our trace and span on to the request
 		c.Request = c.Request.WithContext(ctx)
+		// record the bodies, if they are captured
+		bodyCapture := common.NewBodyCapture(cfg.BodyCapture)
+		if bodyCapture != nil {
+			bodyCapture.CaptureRequestBody(c.Request)
+			c.Writer = &bodyCaptureWriter{ResponseWriter: c.Writer, bodyCapture: bodyCapture}
+		}
 
 		// pull out any variables in the URL, add the thing we're matching, etc.
 		for _, param := range c.Params {
@@ -50,9 +70,30 @@ func Middleware(queryParams map[string]struct{}) gin.HandlerFunc {
 		// Run the next function in the Middleware chain
 		c.Next()
 		span.AddField("response.status_code", c.Writer.Status())
+		span.AddFields(common.GetHeaderProps(c.Writer.Header(), "response.header.", cfg.HeaderCapture))
+		span.AddFields(bodyCapture.Fields())
 	}
 }
 
+// bodyCaptureWriter records the response body written through a
+// gin.ResponseWriter.
+type bodyCaptureWriter struct {
+	gin.ResponseWriter
+	bodyCapture *common.BodyCapture
+}
+
+func (w *bodyCaptureWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.bodyCapture.RecordResponseBody(w.Header(), p[:n])
+	return n, err
+}
+
+func (w *bodyCaptureWriter) WriteString(s string) (int, error) {
+	n, err := w.ResponseWriter.WriteString(s)
+	w.bodyCapture.RecordResponseBody(w.Header(), []byte(s[:n]))
+	return n, err
+}
+
 // StartSpan is a helper function to start a new span in a gin-gonic context
 // This is required because the gin-gonic handler function expects to receive
 // *gin.Context rather than context.Context
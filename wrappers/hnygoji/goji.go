@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/honeycombio/beeline-go/wrappers/common"
+	"github.com/honeycombio/beeline-go/wrappers/config"
 	"goji.io/v3/middleware"
 	"goji.io/v3/pat"
 )
@@ -14,15 +15,36 @@ import (
 // Middleware is specifically to use with goji's router.Use() function for
 // inserting middleware
 func Middleware(handler http.Handler) http.Handler {
+	return MiddlewareWithConfig(config.HTTPIncomingConfig{})(handler)
+}
+
+// MiddlewareWithConfig returns a middleware like Middleware, for use with
+// router.Use(), that uses the parser hook, header and body capture, filter
+// and sample rate settings of cfg.
+func MiddlewareWithConfig(cfg config.HTTPIncomingConfig) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		return wrapHandler(handler, cfg)
+	}
+}
+
+func wrapHandler(handler http.Handler, cfg config.HTTPIncomingConfig) http.Handler {
 	wrappedHandler := func(w http.ResponseWriter, r *http.Request) {
+		// requests the filter drops are passed on untraced
+		if untraced, traced := common.FilterHTTPRequest(r, cfg); !traced {
+			handler.ServeHTTP(w, untraced)
+			return
+		}
 		// get a new context with our trace from the request, and add common fields
-		ctx, span := common.StartSpanOrTraceFromHTTP(r)
+		ctx, span := common.StartSpanOrTraceFromHTTPWithConfig(r, cfg)
 		defer span.Send()
 		// push the context with our trace and span on to the request
 		r = r.WithContext(ctx)
 
 		// replace the writer with our wrapper to catch the status code
 		wrappedWriter := common.NewResponseWriter(w)
+		// record the bodies, if they are captured
+		bodyCapture := common.NewBodyCapture(cfg.BodyCapture)
+		bodyCapture.CaptureRequestBody(r)
 
 		// get bits about the handler
 		handler := middleware.Handler(ctx)
@@ -54,11 +76,13 @@ func Middleware(handler http.Handler) http.Handler {
 			}
 		}
 		// TODO get all the parameters and their values
-		handler.ServeHTTP(wrappedWriter.Wrapped, r)
+		handler.ServeHTTP(bodyCapture.WrapResponseWriter(wrappedWriter.Wrapped), r)
 		if wrappedWriter.Status == 0 {
 			wrappedWriter.Status = 200
 		}
 		span.AddField("response.status_code", wrappedWriter.Status)
+		span.AddFields(common.GetHeaderProps(wrappedWriter.Wrapped.Header(), "response.header.", cfg.HeaderCapture))
+		span.AddFields(bodyCapture.Fields())
 	}
 	return http.HandlerFunc(wrappedHandler)
 }
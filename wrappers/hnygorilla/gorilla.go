@@ -7,20 +7,42 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/honeycombio/beeline-go/wrappers/common"
+	"github.com/honeycombio/beeline-go/wrappers/config"
 )
 
 // Middleware is a gorilla middleware to add Honeycomb instrumentation to the
 // gorilla muxer.
 func Middleware(handler http.Handler) http.Handler {
+	return MiddlewareWithConfig(config.HTTPIncomingConfig{})(handler)
+}
+
+// MiddlewareWithConfig returns a gorilla middleware like Middleware that
+// uses the parser hook, header and body capture, filter and sample rate
+// settings of cfg.
+func MiddlewareWithConfig(cfg config.HTTPIncomingConfig) mux.MiddlewareFunc {
+	return func(handler http.Handler) http.Handler {
+		return wrapHandler(handler, cfg)
+	}
+}
+
+func wrapHandler(handler http.Handler, cfg config.HTTPIncomingConfig) http.Handler {
 	wrappedHandler := func(w http.ResponseWriter, r *http.Request) {
+		// requests the filter drops are passed on untraced
+		if untraced, traced := common.FilterHTTPRequest(r, cfg); !traced {
+			handler.ServeHTTP(w, untraced)
+			return
+		}
 		// get a new context with our trace from the request, and add common fields
-		ctx, span := common.StartSpanOrTraceFromHTTP(r)
+		ctx, span := common.StartSpanOrTraceFromHTTPWithConfig(r, cfg)
 		defer span.Send()
 		// push the context with our trace and span on to the request
 		r = r.WithContext(ctx)
 
 		// replace the writer with our wrapper to catch the status code
 		wrappedWriter := common.NewResponseWriter(w)
+		// record the bodies, if they are captured
+		bodyCapture := common.NewBodyCapture(cfg.BodyCapture)
+		bodyCapture.CaptureRequestBody(r)
 		// pull out any variables in the URL, add the thing we're matching, etc.
 		vars := mux.Vars(r)
 		for k, v := range vars {
@@ -54,7 +76,7 @@ func Middleware(handler http.Handler) http.Handler {
 				span.AddField("handler.route", path)
 			}
 		}
-		handler.ServeHTTP(wrappedWriter.Wrapped, r)
+		handler.ServeHTTP(bodyCapture.WrapResponseWriter(wrappedWriter.Wrapped), r)
 		if wrappedWriter.Status == 0 {
 			wrappedWriter.Status = 200
 		}
@@ -68,6 +90,8 @@ func Middleware(handler http.Handler) http.Handler {
 			span.AddField("response.content_encoding", ce)
 		}
 		span.AddField("response.status_code", wrappedWriter.Status)
+		span.AddFields(common.GetHeaderProps(wrappedWriter.Wrapped.Header(), "response.header.", cfg.HeaderCapture))
+		span.AddFields(bodyCapture.Fields())
 	}
 	return http.HandlerFunc(wrappedHandler)
 }
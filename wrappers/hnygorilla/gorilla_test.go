@@ -7,6 +7,7 @@ import (
 
 	"github.com/gorilla/mux"
 	beeline "github.com/honeycombio/beeline-go"
+	"github.com/honeycombio/beeline-go/wrappers/config"
 	libhoney "github.com/honeycombio/libhoney-go"
 	"github.com/honeycombio/libhoney-go/transmission"
 	"github.com/stretchr/testify/assert"
@@ -65,4 +66,26 @@ func TestGorillaMiddleware(t *testing.T) {
 		assert.Equal(t, 2, len(evs))
 		assert.Equal(t, "testHandler", evs[1].Data["name"])
 	})
+	t.Run("header capture", func(t *testing.T) {
+		router := mux.NewRouter()
+		router.Use(MiddlewareWithConfig(config.HTTPIncomingConfig{
+			HeaderCapture: config.HTTPHeaderCapture{Allow: []string{"Accept", "Cookie", "Set-Cookie", "X-Request-Id"}},
+		}))
+		router.HandleFunc("/cookie", func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Set-Cookie", "session=abc")
+			w.Header().Set("X-Request-Id", "42")
+		})
+		r, _ := http.NewRequest("GET", "/cookie", nil)
+		r.Header.Set("Accept", "text/plain")
+		r.Header.Set("Cookie", "session=abc")
+		router.ServeHTTP(httptest.NewRecorder(), r)
+
+		evs := mo.Events()
+		assert.Equal(t, 3, len(evs))
+		fields := evs[2].Data
+		assert.Equal(t, "text/plain", fields["request.header.accept"])
+		assert.Equal(t, "[REDACTED]", fields["request.header.cookie"])
+		assert.Equal(t, "[REDACTED]", fields["response.header.set_cookie"])
+		assert.Equal(t, "42", fields["response.header.x_request_id"])
+	})
 }
@@ -16,6 +16,24 @@
 // Requests received by the server will now generate Honeycomb events, with
 // metadata related to the request included as fields.
 //
-// Please note that only unary RPCs are supported at this time. Support for
-// streaming RPCs may be added later.
+// Streaming RPCs are instrumented the same way, with a span for the lifetime
+// of each stream:
+//
+//     serverOpts := []grpc.ServerOption{
+//         grpc.UnaryInterceptor(hnygrpc.UnaryServerInterceptorWithConfig(cfg)),
+//         grpc.StreamInterceptor(hnygrpc.StreamServerInterceptorWithConfig(cfg)),
+//     }
+//
+// For status codes, payload sizes, compression, time to first byte and
+// deadlines, also register the stats handlers. They share the span the
+// interceptors create, and can be used without the interceptors too:
+//
+//     server := grpc.NewServer(
+//         grpc.StatsHandler(hnygrpc.NewServerStatsHandler()),
+//         grpc.UnaryInterceptor(hnygrpc.UnaryServerInterceptor()),
+//     )
+//     conn, err := grpc.NewClient(target,
+//         grpc.WithStatsHandler(hnygrpc.NewClientStatsHandler()),
+//         grpc.WithUnaryInterceptor(hnygrpc.UnaryClientInterceptor()),
+//     )
 package hnygrpc
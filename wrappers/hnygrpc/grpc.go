@@ -3,16 +3,20 @@ package hnygrpc
 import (
 	"context"
 	"net"
+	"net/url"
 	"reflect"
 	"runtime"
+	"strings"
 
 	"github.com/honeycombio/beeline-go/propagation"
 	"github.com/honeycombio/beeline-go/timer"
 	"github.com/honeycombio/beeline-go/trace"
+	"github.com/honeycombio/beeline-go/wrappers/common"
 	"github.com/honeycombio/beeline-go/wrappers/config"
 	"github.com/honeycombio/libhoney-go"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
@@ -48,33 +52,20 @@ func getMetadataStringValue(md metadata.MD, key string) string {
 	return ""
 }
 
-// startSpanOrTraceFromUnaryGRPC checks to see if a trace already exists in the
+// startSpanOrTraceFromGRPC checks to see if a trace already exists in the
 // provided context before creating either a root span or a child span of the
 // existing active span. The function understands trace parser hooks, so if one
-// is provided, it'll use it to parse the incoming request for trace context.
-func startSpanOrTraceFromUnaryGRPC(
+// is provided in cfg, it'll use it to parse the incoming request for trace
+// context, which is only continued if cfg.TrustPolicy trusts the RPC.
+func startSpanOrTraceFromGRPC(
 	ctx context.Context,
-	info *grpc.UnaryServerInfo,
-	parserHook config.GRPCTraceParserHook,
+	cfg config.GRPCIncomingConfig,
 ) (context.Context, *trace.Span) {
 	span := trace.GetSpanFromContext(ctx)
 	if span == nil {
 		// no active span, create a new trace
-		var tr *trace.Trace
-		md, ok := metadata.FromIncomingContext(ctx)
-		if ok {
-			if parserHook == nil {
-				beelineHeader := getMetadataStringValue(md, propagation.TracePropagationGRPCHeader)
-				prop, _ := propagation.UnmarshalHoneycombTraceContext(beelineHeader)
-				ctx, tr = trace.NewTrace(ctx, prop)
-			} else {
-				prop := parserHook(ctx)
-				ctx, tr = trace.NewTraceFromPropagationContext(ctx, prop)
-			}
-		} else {
-			ctx, tr = trace.NewTrace(ctx, nil)
-		}
-		span = tr.GetRootSpan()
+		prop := getPropagationContext(ctx, cfg.GRPCParserHook)
+		ctx, span = common.NewTraceFromUpstream(ctx, prop, trustsGRPC(ctx, cfg.TrustPolicy), cfg.TrustPolicy)
 	} else {
 		// create new span as child of active span.
 		ctx, span = span.CreateChild(ctx)
@@ -82,6 +73,85 @@ func startSpanOrTraceFromUnaryGRPC(
 	return ctx, span
 }
 
+// trustsGRPC reports whether policy trusts the trace context of the RPC whose
+// server context is ctx.
+func trustsGRPC(ctx context.Context, policy config.TrustPolicy) bool {
+	var remoteAddr string
+	var verified bool
+	if pr, ok := peer.FromContext(ctx); ok {
+		if pr.Addr != net.Addr(nil) {
+			remoteAddr = pr.Addr.String()
+		}
+		if info, ok := pr.AuthInfo.(credentials.TLSInfo); ok {
+			verified = len(info.State.VerifiedChains) > 0
+		}
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	header := func(name string) string {
+		return getMetadataStringValue(md, strings.ToLower(name))
+	}
+	return common.TrustsClient(policy, remoteAddr, header, verified)
+}
+
+// getPropagationContext reads the upstream trace context from the incoming
+// metadata in ctx, using parserHook if there is one. It returns nil if there
+// is no metadata.
+func getPropagationContext(ctx context.Context, parserHook config.GRPCTraceParserHook) *propagation.PropagationContext {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	if parserHook != nil {
+		return parserHook(ctx)
+	}
+	var prop *propagation.PropagationContext
+	if propagation.GlobalConfig.Propagator != nil {
+		if prop, _ = propagation.GlobalConfig.Propagator.Extract(propagation.MetadataCarrier(md)); prop != nil {
+			return prop
+		}
+	} else {
+		beelineHeader := getMetadataStringValue(md, propagation.TracePropagationGRPCHeader)
+		prop, _ = propagation.UnmarshalHoneycombTraceContext(beelineHeader)
+	}
+	if baggageHeader := getMetadataStringValue(md, propagation.BaggageHeader); baggageHeader != "" {
+		if prop == nil {
+			prop = &propagation.PropagationContext{}
+		}
+		prop.Baggage, _ = propagation.ParseBaggage(baggageHeader)
+	}
+	return prop
+}
+
+// filterGRPC reports whether the RPC to fullMethod should be traced,
+// according to cfg.Filter. If it shouldn't, it also returns ctx with the
+// upstream trace context of the RPC put in it, if cfg.TrustPolicy trusts it,
+// which should be passed to the handler so that its outgoing calls continue
+// the trace.
+func filterGRPC(ctx context.Context, fullMethod string, cfg config.GRPCIncomingConfig) (context.Context, bool) {
+	if cfg.Filter == nil || cfg.Filter(fullMethod) {
+		return ctx, true
+	}
+	if trace.GetSpanFromContext(ctx) != nil {
+		// the span in the context already carries the trace
+		return ctx, false
+	}
+	if !trustsGRPC(ctx, cfg.TrustPolicy) {
+		// the handler's outgoing calls start new traces
+		return ctx, false
+	}
+	if prop := getPropagationContext(ctx, cfg.GRPCParserHook); prop != nil && prop.IsValid() {
+		ctx = trace.PutPropagationContextInContext(ctx, prop)
+	}
+	return ctx, false
+}
+
+// IgnoreHealthChecks can be used as the Filter of a config.GRPCIncomingConfig
+// to leave out the RPCs of the standard health checking service,
+// grpc.health.v1.Health, which load balancers and orchestrators call often.
+func IgnoreHealthChecks(fullMethod string) bool {
+	return !strings.HasPrefix(fullMethod, "/grpc.health.v1.Health/")
+}
+
 // addFields just adds available information about a gRPC request to the provided span.
 func addFields(ctx context.Context, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler, span *trace.Span) {
 	handlerName := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
@@ -90,7 +160,12 @@ func addFields(ctx context.Context, info *grpc.UnaryServerInfo, handler grpc.Una
 	span.AddField("meta.type", "grpc_request")
 	span.AddField("handler.name", handlerName)
 	span.AddField("handler.method", info.FullMethod)
+	addRequestFields(ctx, span)
+}
 
+// addRequestFields adds the peer address and selected metadata of a gRPC
+// request to the provided span.
+func addRequestFields(ctx context.Context, span *trace.Span) {
 	pr, ok := peer.FromContext(ctx)
 	if ok {
 		// if we have an address, put it on the span
@@ -117,6 +192,10 @@ func addFields(ctx context.Context, info *grpc.UnaryServerInfo, handler grpc.Una
 //
 // Events created from GRPC interceptors will contain information from the gRPC metadata, if
 // it exists, as well as information about the handler used and method being called.
+//
+// If the server also has a stats handler from NewServerStatsHandler, the
+// interceptor adds its fields to the span created by the stats handler. RPCs
+// dropped by the config's Filter are passed to the handler untraced.
 func UnaryServerInterceptorWithConfig(cfg config.GRPCIncomingConfig) grpc.UnaryServerInterceptor {
 	return func(
 		ctx context.Context,
@@ -124,8 +203,15 @@ func UnaryServerInterceptorWithConfig(cfg config.GRPCIncomingConfig) grpc.UnaryS
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler,
 	) (interface{}, error) {
-		ctx, span := startSpanOrTraceFromUnaryGRPC(ctx, info, cfg.GRPCParserHook)
-		defer span.Send()
+		// RPCs the filter drops are passed on untraced
+		if untraced, traced := filterGRPC(ctx, info.FullMethod, cfg); !traced {
+			return handler(untraced, req)
+		}
+		span := serverSpanFromStats(ctx)
+		if span == nil {
+			ctx, span = startSpanOrTraceFromGRPC(ctx, cfg)
+			defer span.Send()
+		}
 
 		addFields(ctx, info, handler, span)
 		resp, err := handler(ctx, req)
@@ -151,7 +237,10 @@ func UnaryServerInterceptor() grpc.UnaryServerInterceptor {
 // passed a config.GRPCOutgoingConfig with a GRPCTracePropagationHook, the hook
 // will be called when populating the gRPC metadata, allowing it to specify how
 // trace context information should be included in the metadata (e.g. if the
-// remote server expects it to come in a specific format).
+// remote server expects it to come in a specific format). The config's
+// DestinationPolicy limits the trace context sent to each target; spans of
+// RPCs sent less than the full context get a `meta.propagation` field of
+// "ids" or "none".
 func UnaryClientInterceptorWithConfig(cfg config.GRPCOutgoingConfig) grpc.UnaryClientInterceptor {
 	return func(
 		ctx context.Context,
@@ -173,6 +262,17 @@ func UnaryClientInterceptorWithConfig(cfg config.GRPCOutgoingConfig) grpc.UnaryC
 			ev.AddField("name", method)
 			ev.AddField("meta.type", "grpc_client")
 			ev.AddField("request.target", cc.Target())
+			// pass on the trace of an untraced incoming request, if there is one
+			if prop, truncated := trace.GetOutgoingPropagationContextFromContext(ctx); prop != nil {
+				if truncated {
+					ev.AddField("meta.propagation_truncated", true)
+				}
+				var level common.PropagationLevel
+				ctx, level = injectMetadata(ctx, prop, cfg, cc.Target())
+				if level != common.PropagateFull {
+					ev.AddField("meta.propagation", level.String())
+				}
+			}
 
 			err := invoker(ctx, method, req, reply, cc, opts...)
 			if err != nil {
@@ -190,22 +290,11 @@ func UnaryClientInterceptorWithConfig(cfg config.GRPCOutgoingConfig) grpc.UnaryC
 		span.AddField("meta.type", "grpc_client")
 		span.AddField("request.target", cc.Target())
 
-		md, ok := metadata.FromOutgoingContext(ctx)
-		if !ok {
-			md = metadata.New(nil)
-		} else {
-			// Modifying the result of FromOutgoingContext may race, so copy instead.
-			md = md.Copy()
+		ctx, level := injectMetadata(ctx, span.PropagationContext(), cfg, cc.Target())
+		if level != common.PropagateFull {
+			span.AddField("meta.propagation", level.String())
 		}
-
-		if cfg.GRPCPropagationHook == nil {
-			md.Set(propagation.TracePropagationGRPCHeader, span.SerializeHeaders())
-		} else {
-			// If a propagationHook exists, call it to get a metadata to append.
-			md = metadata.Join(md, cfg.GRPCPropagationHook(span.PropagationContext()))
-		}
-
-		ctx = metadata.NewOutgoingContext(ctx, md)
+		ctx = withClientStats(ctx, span)
 		err := invoker(ctx, method, req, reply, cc, opts...)
 		if err != nil {
 			span.AddField("error", err.Error())
@@ -214,6 +303,61 @@ func UnaryClientInterceptorWithConfig(cfg config.GRPCOutgoingConfig) grpc.UnaryC
 	}
 }
 
+// injectMetadata returns a copy of ctx whose outgoing gRPC metadata carries
+// as much of the trace propagation context prop as the destination policy of
+// cfg allows for target, serialized by its propagation hook if one is
+// provided. It also returns how much of the context that was.
+func injectMetadata(
+	ctx context.Context,
+	prop *propagation.PropagationContext,
+	cfg config.GRPCOutgoingConfig,
+	target string,
+) (context.Context, common.PropagationLevel) {
+	host, port := targetHostPort(target)
+	level := common.DestinationPropagation(cfg.DestinationPolicy, host, port)
+	prop = common.LimitPropagationContext(prop, level)
+	if prop == nil {
+		return ctx, level
+	}
+	propagationHook := cfg.GRPCPropagationHook
+
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if !ok {
+		md = metadata.New(nil)
+	} else {
+		// Modifying the result of FromOutgoingContext may race, so copy instead.
+		md = md.Copy()
+	}
+
+	if propagationHook == nil && propagation.GlobalConfig.Propagator != nil {
+		propagation.GlobalConfig.Propagator.Inject(prop, propagation.MetadataCarrier(md))
+	} else if propagationHook == nil {
+		md.Set(propagation.TracePropagationGRPCHeader, propagation.MarshalHoneycombTraceContext(prop))
+		if prop.Baggage.Len() > 0 {
+			md.Set(propagation.BaggageHeader, prop.Baggage.String())
+		}
+	} else {
+		// If a propagationHook exists, call it to get a metadata to append.
+		md = metadata.Join(md, propagationHook(prop))
+	}
+
+	return metadata.NewOutgoingContext(ctx, md), level
+}
+
+// targetHostPort returns the host and port of the endpoint of a gRPC target
+// such as "dns:///api.internal:443" or "api.internal:443". The port is empty
+// if the target doesn't have one.
+func targetHostPort(target string) (string, string) {
+	if u, err := url.Parse(target); err == nil && u.Scheme != "" && u.Opaque == "" {
+		// the endpoint is the path of targets with a scheme
+		target = strings.TrimPrefix(u.Path, "/")
+	}
+	if host, port, err := net.SplitHostPort(target); err == nil {
+		return host, port
+	}
+	return target, ""
+}
+
 // UnaryClientInterceptor is identical to UnaryClientInterceptorWithConfig called
 // with an empty config.GRPCOutgoingConfig.
 func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
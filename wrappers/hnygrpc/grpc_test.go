@@ -2,7 +2,10 @@ package hnygrpc
 
 import (
 	"context"
+	"io"
+	"net"
 	"testing"
+	"time"
 
 	beeline "github.com/honeycombio/beeline-go"
 	"github.com/honeycombio/beeline-go/propagation"
@@ -14,22 +17,25 @@ import (
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 func TestStartSpanOrTrace(t *testing.T) {
-	info := &grpc.UnaryServerInfo{
-		FullMethod: "test.method",
-	}
 	// no current span, no parser hook, expect a new trace
 	ctx := context.Background()
-	ctx, span := startSpanOrTraceFromUnaryGRPC(ctx, info, nil)
+	ctx, span := startSpanOrTraceFromGRPC(ctx, config.GRPCIncomingConfig{})
 	assert.Equal(t, 0, len(span.GetChildren()), "Span should not have children")
 	assert.Equal(t, "", span.GetParentID(), "Span should not have parent")
 
 	// now let's create a child span
 	ctx = trace.PutSpanInContext(ctx, span)
-	ctx, spanTwo := startSpanOrTraceFromUnaryGRPC(ctx, info, nil)
+	ctx, spanTwo := startSpanOrTraceFromGRPC(ctx, config.GRPCIncomingConfig{})
 	assert.Equal(t, 1, len(span.GetChildren()), "Should have one child span")
 	assert.Equal(t, span, spanTwo.GetParent(), "Span should have been created as child")
 
@@ -38,7 +44,7 @@ func TestStartSpanOrTrace(t *testing.T) {
 	ctx = metadata.NewIncomingContext(ctx, metadata.New(map[string]string{
 		"content-type": "application/grpc",
 	}))
-	ctx, spanThree := startSpanOrTraceFromUnaryGRPC(ctx, info, nil)
+	ctx, spanThree := startSpanOrTraceFromGRPC(ctx, config.GRPCIncomingConfig{})
 	assert.Equal(t, 0, len(spanThree.GetChildren()), "span should not have children")
 	assert.Equal(t, "", span.GetParentID(), "Span should not have parent")
 
@@ -46,7 +52,7 @@ func TestStartSpanOrTrace(t *testing.T) {
 	ctx = metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{
 		"x-honeycomb-trace": "1;trace_id=4bf92f3577b34da6a3ce929d0e0e473,parent_id=00f067aa0ba902b7,context=",
 	}))
-	ctx, spanFour := startSpanOrTraceFromUnaryGRPC(ctx, info, nil)
+	ctx, spanFour := startSpanOrTraceFromGRPC(ctx, config.GRPCIncomingConfig{})
 	assert.Equal(t, 0, len(spanFour.GetChildren()), "span should not have children")
 	assert.Equal(t, "00f067aa0ba902b7", spanFour.GetParentID(), "Expected parent_id from header")
 	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e473", spanFour.GetTrace().GetTraceID(), "Expected trace id from header")
@@ -61,10 +67,21 @@ func TestStartSpanOrTrace(t *testing.T) {
 			ParentID: "aaaaaaaaaaaaaaaa",
 		}
 	}
-	ctx, spanFive := startSpanOrTraceFromUnaryGRPC(ctx, info, parserHook)
+	ctx, spanFive := startSpanOrTraceFromGRPC(ctx, config.GRPCIncomingConfig{GRPCParserHook: parserHook})
 	assert.Equal(t, 0, len(spanFive.GetChildren()), "span should not have children")
 	assert.Equal(t, "aaaaaaaaaaaaaaaa", spanFive.GetParentID(), "Expected parent id from propagation context")
 	assert.Equal(t, "fffffffffffffffffffffffffffffff", spanFive.GetTrace().GetTraceID(), "Expected trace id from propagation context")
+
+	// metadata, no parser hook, global propagator
+	propagation.GlobalConfig.Propagator = propagation.W3CPropagator{}
+	defer func() { propagation.GlobalConfig.Propagator = nil }()
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{
+		"traceparent":       "00-7f042f75651d9782dcff93a45fa99be0-c998e73e5420f609-01",
+		"x-honeycomb-trace": "1;trace_id=4bf92f3577b34da6a3ce929d0e0e473,parent_id=00f067aa0ba902b7,context=",
+	}))
+	_, spanSix := startSpanOrTraceFromGRPC(ctx, config.GRPCIncomingConfig{})
+	assert.Equal(t, "c998e73e5420f609", spanSix.GetParentID(), "Expected parent id from the global propagator")
+	assert.Equal(t, "7f042f75651d9782dcff93a45fa99be0", spanSix.GetTrace().GetTraceID(), "Expected trace id from the global propagator")
 }
 
 func TestUnaryInterceptor(t *testing.T) {
@@ -133,3 +150,414 @@ func TestUnaryInterceptor(t *testing.T) {
 	assert.True(t, ok, "Status message must exist on middleware generated event")
 	assert.Equal(t, codes.OK.String(), statusMsg, "human-readable status must exist")
 }
+
+// fakeServerStream is a grpc.ServerStream that receives a fixed number of
+// messages.
+type fakeServerStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	messages int
+}
+
+func (ss *fakeServerStream) Context() context.Context    { return ss.ctx }
+func (ss *fakeServerStream) SendMsg(m interface{}) error { return nil }
+func (ss *fakeServerStream) RecvMsg(m interface{}) error {
+	if ss.messages == 0 {
+		return io.EOF
+	}
+	ss.messages--
+	return nil
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	mo := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:       "placeholder",
+		Dataset:      "placeholder",
+		APIHost:      "placeholder",
+		Transmission: mo})
+	assert.Equal(t, nil, err)
+	beeline.Init(beeline.Config{Client: client})
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{
+		"x-honeycomb-trace": "1;trace_id=4bf92f3577b34da6a3ce929d0e0e473,parent_id=00f067aa0ba902b7,context=",
+	}))
+	info := &grpc.StreamServerInfo{
+		FullMethod:     "test.stream",
+		IsClientStream: true,
+		IsServerStream: true,
+	}
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		_, child := beeline.StartSpan(stream.Context(), "child")
+		child.Send()
+		for stream.RecvMsg(nil) == nil {
+			stream.SendMsg(nil)
+		}
+		return status.Error(codes.Aborted, "done")
+	}
+	interceptor := StreamServerInterceptorWithConfig(config.GRPCIncomingConfig{MessageEvents: true})
+	err = interceptor(nil, &fakeServerStream{ctx: ctx, messages: 2}, info, handler)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	evs := mo.Events()
+	// child span, the stream span and four message events
+	assert.Equal(t, 6, len(evs))
+	fields := evs[1].Data
+	assert.Equal(t, "00f067aa0ba902b7", fields["trace.parent_id"], "the stream span should continue the trace")
+	assert.Equal(t, fields["trace.span_id"], evs[0].Data["trace.parent_id"], "handlers should be able to create child spans")
+	assert.Equal(t, "test.stream", fields["handler.method"])
+	assert.Equal(t, true, fields["handler.client_stream"])
+	assert.Equal(t, codes.Aborted, fields["response.grpc_status_code"])
+	assert.Equal(t, int64(2), fields["stream.messages_sent"])
+	assert.Equal(t, int64(2), fields["stream.messages_received"])
+	var types []interface{}
+	for _, ev := range evs[2:] {
+		assert.Equal(t, "message", ev.Data["name"])
+		types = append(types, ev.Data["message.type"])
+	}
+	assert.Equal(t, []interface{}{"RECEIVED", "SENT", "RECEIVED", "SENT"}, types)
+}
+
+// fakeClientStream is a grpc.ClientStream whose server sends a fixed number
+// of messages and then ends the stream with err.
+type fakeClientStream struct {
+	grpc.ClientStream
+	messages int
+	err      error
+}
+
+func (cs *fakeClientStream) SendMsg(m interface{}) error { return nil }
+func (cs *fakeClientStream) CloseSend() error            { return nil }
+func (cs *fakeClientStream) RecvMsg(m interface{}) error {
+	if cs.messages == 0 {
+		return cs.err
+	}
+	cs.messages--
+	return nil
+}
+
+func TestStreamClientInterceptor(t *testing.T) {
+	mo := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:       "placeholder",
+		Dataset:      "placeholder",
+		APIHost:      "placeholder",
+		Transmission: mo})
+	assert.Equal(t, nil, err)
+	beeline.Init(beeline.Config{Client: client})
+
+	cc, err := grpc.NewClient("passthrough:///test", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer cc.Close()
+
+	var outgoing metadata.MD
+	streamer := func(serverErr error) grpc.Streamer {
+		return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			outgoing, _ = metadata.FromOutgoingContext(ctx)
+			return &fakeClientStream{messages: 2, err: serverErr}, nil
+		}
+	}
+	desc := &grpc.StreamDesc{ServerStreams: true}
+	interceptor := StreamClientInterceptor()
+
+	ctx, parent := beeline.StartSpan(context.Background(), "parent")
+	stream, err := interceptor(ctx, desc, cc, "test.stream", streamer(io.EOF))
+	assert.NoError(t, err)
+	assert.NoError(t, stream.SendMsg(nil))
+	assert.NoError(t, stream.CloseSend())
+	for stream.RecvMsg(nil) == nil {
+	}
+	assert.Equal(t, 1, len(mo.Events()), "the span should be sent when the stream ends")
+	fields := mo.Events()[0].Data
+	assert.Equal(t, parent.GetSpanID(), fields["trace.parent_id"])
+	assert.Contains(t, outgoing.Get(propagation.TracePropagationGRPCHeader)[0], fields["trace.span_id"], "trace context should be propagated")
+	assert.Equal(t, "test.stream", fields["name"])
+	assert.Equal(t, codes.OK, fields["response.grpc_status_code"])
+	assert.Equal(t, int64(1), fields["stream.messages_sent"])
+	assert.Equal(t, int64(2), fields["stream.messages_received"])
+	assert.NotContains(t, fields, "error")
+
+	// a stream that fails
+	stream, err = interceptor(ctx, desc, cc, "test.stream", streamer(status.Error(codes.Unavailable, "gone")))
+	assert.NoError(t, err)
+	for stream.RecvMsg(nil) == nil {
+	}
+	assert.Equal(t, 2, len(mo.Events()))
+	assert.Equal(t, codes.Unavailable, mo.Events()[1].Data["response.grpc_status_code"])
+
+	// an abandoned stream is sent when its context is cancelled
+	cancelCtx, cancel := context.WithCancel(ctx)
+	_, err = interceptor(cancelCtx, desc, cc, "test.stream", streamer(io.EOF))
+	assert.NoError(t, err)
+	cancel()
+	assert.Eventually(t, func() bool { return len(mo.Events()) == 3 }, time.Second, time.Millisecond)
+	assert.Equal(t, codes.Canceled, mo.Events()[2].Data["response.grpc_status_code"])
+
+	// a stream that outlives the span that opened it is still sent in full
+	stream, err = interceptor(ctx, desc, cc, "test.stream", streamer(io.EOF))
+	assert.NoError(t, err)
+	parent.Send()
+	assert.Equal(t, 4, len(mo.Events()))
+	assert.Equal(t, "parent", mo.Events()[3].Data["name"])
+	for stream.RecvMsg(nil) == nil {
+	}
+	assert.Equal(t, 5, len(mo.Events()))
+	fields = mo.Events()[4].Data
+	assert.Equal(t, parent.GetSpanID(), fields["trace.parent_id"])
+	assert.Equal(t, codes.OK, fields["response.grpc_status_code"])
+	assert.Equal(t, int64(2), fields["stream.messages_received"])
+}
+
+func TestStatsHandlers(t *testing.T) {
+	mo := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:       "placeholder",
+		Dataset:      "placeholder",
+		APIHost:      "placeholder",
+		Transmission: mo})
+	assert.Equal(t, nil, err)
+	beeline.Init(beeline.Config{Client: client})
+
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(
+		grpc.StatsHandler(NewServerStatsHandler()),
+		grpc.UnaryInterceptor(UnaryServerInterceptor()),
+	)
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	go server.Serve(lis)
+	defer server.Stop()
+
+	cc, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(NewClientStatsHandler()),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor()),
+	)
+	assert.NoError(t, err)
+	defer cc.Close()
+
+	ctx, parent := beeline.StartSpan(context.Background(), "parent")
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	_, err = healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{Service: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	parent.Send()
+
+	assert.Eventually(t, func() bool { return len(mo.Events()) == 5 }, time.Second, time.Millisecond)
+	var servers, clients []map[string]interface{}
+	for _, ev := range mo.Events() {
+		switch ev.Data["meta.type"] {
+		case "grpc_request":
+			servers = append(servers, ev.Data)
+		case "grpc_client":
+			clients = append(clients, ev.Data)
+		}
+	}
+	assert.Equal(t, 2, len(servers), "the interceptor and stats handler should share one server span")
+	assert.Equal(t, 2, len(clients), "the interceptor and stats handler should share one client span")
+
+	for _, fields := range servers {
+		assert.Equal(t, "/grpc.health.v1.Health/Check", fields["handler.method"])
+		assert.Contains(t, fields, "handler.name", "the server interceptor should add its fields")
+	}
+	for _, fields := range clients {
+		assert.Equal(t, "/grpc.health.v1.Health/Check", fields["name"])
+		assert.Equal(t, parent.GetSpanID(), fields["trace.parent_id"])
+	}
+
+	// find the successful and failed calls on each side
+	byCode := func(spans []map[string]interface{}, code codes.Code) map[string]interface{} {
+		for _, fields := range spans {
+			if fields["grpc.code"] == code.String() {
+				return fields
+			}
+		}
+		t.Fatalf("no span with code %s", code)
+		return nil
+	}
+	serverOK, clientOK := byCode(servers, codes.OK), byCode(clients, codes.OK)
+	assert.Equal(t, clientOK["trace.span_id"], serverOK["trace.parent_id"], "trace context should be propagated once")
+	assert.Equal(t, 0, serverOK["grpc.status_code"])
+	assert.Equal(t, false, serverOK["grpc.deadline_exceeded"])
+	assert.Greater(t, serverOK["grpc.response.bytes"], 0)
+	assert.Greater(t, serverOK["grpc.response.wire_bytes"], serverOK["grpc.response.bytes"])
+	assert.Equal(t, serverOK["grpc.response.bytes"], clientOK["grpc.response.bytes"])
+	assert.Contains(t, clientOK, "grpc.time_to_first_byte_ms")
+	assert.Contains(t, serverOK, "grpc.deadline_remaining_ms")
+	assert.Greater(t, clientOK["grpc.deadline_remaining_ms"], float64(59000))
+	assert.Contains(t, clientOK, "grpc.peer.address")
+
+	serverFailed, clientFailed := byCode(servers, codes.NotFound), byCode(clients, codes.NotFound)
+	assert.Equal(t, int(codes.NotFound), serverFailed["grpc.status_code"])
+	assert.Equal(t, int(codes.NotFound), clientFailed["grpc.status_code"])
+	assert.Contains(t, clientFailed, "error")
+}
+
+func TestFilter(t *testing.T) {
+	mo := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:       "placeholder",
+		Dataset:      "placeholder",
+		APIHost:      "placeholder",
+		Transmission: mo})
+	assert.Equal(t, nil, err)
+	beeline.Init(beeline.Config{Client: client})
+
+	assert.False(t, IgnoreHealthChecks("/grpc.health.v1.Health/Watch"))
+	assert.True(t, IgnoreHealthChecks("/helloworld.Greeter/SayHello"))
+
+	cfg := config.GRPCIncomingConfig{Filter: IgnoreHealthChecks}
+	var handlerCtx context.Context
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(
+		grpc.StatsHandler(NewServerStatsHandlerWithConfig(cfg)),
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			return UnaryServerInterceptorWithConfig(cfg)(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				handlerCtx = ctx
+				return handler(ctx, req)
+			})
+		}),
+	)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(lis)
+	defer server.Stop()
+
+	cc, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor()),
+	)
+	assert.NoError(t, err)
+	defer cc.Close()
+
+	ctx, parent := beeline.StartSpan(context.Background(), "parent")
+	_, err = healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	parent.Send()
+
+	evs := mo.Events()
+	assert.Equal(t, 2, len(evs), "only the client and parent spans are sent")
+	assert.Equal(t, "grpc_client", evs[0].Data["meta.type"])
+	assert.Nil(t, trace.GetSpanFromContext(handlerCtx))
+	prop := trace.GetPropagationContextFromContext(handlerCtx)
+	if assert.NotNil(t, prop, "the handler can continue the trace") {
+		assert.Equal(t, parent.GetTrace().GetTraceID(), prop.TraceID)
+		assert.Equal(t, evs[0].Data["trace.span_id"], prop.ParentID)
+	}
+}
+
+func TestTargetHostPort(t *testing.T) {
+	testCases := []struct {
+		target string
+		host   string
+		port   string
+	}{
+		{"dns:///api.internal:443", "api.internal", "443"},
+		{"dns://8.8.8.8/api.internal", "api.internal", ""},
+		{"localhost:50051", "localhost", "50051"},
+		{"10.0.0.1:50051", "10.0.0.1", "50051"},
+		{"[::1]:50051", "::1", "50051"},
+		{"passthrough:///bufnet", "bufnet", ""},
+		{"api.internal", "api.internal", ""},
+	}
+	for _, tc := range testCases {
+		host, port := targetHostPort(tc.target)
+		assert.Equal(t, tc.host, host, tc.target)
+		assert.Equal(t, tc.port, port, tc.target)
+	}
+}
+
+func TestTrustPolicy(t *testing.T) {
+	mo := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:       "placeholder",
+		Dataset:      "placeholder",
+		APIHost:      "placeholder",
+		Transmission: mo})
+	assert.Equal(t, nil, err)
+	beeline.Init(beeline.Config{Client: client})
+
+	interceptor := UnaryServerInterceptorWithConfig(config.GRPCIncomingConfig{
+		TrustPolicy: config.TrustPolicy{
+			Networks:    []string{"10.0.0.0/8"},
+			Header:      "X-Internal-Token",
+			HeaderValue: "s3cret",
+		},
+	})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return req, nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "test.method"}
+	call := func(remoteAddr string, kv ...string) map[string]interface{} {
+		md := metadata.Pairs(append(kv, propagation.TracePropagationGRPCHeader, "1;trace_id=abcdef,parent_id=12345")...)
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		addr, _ := net.ResolveTCPAddr("tcp", remoteAddr)
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+		offset := len(mo.Events())
+		_, err := interceptor(ctx, nil, info, handler)
+		assert.NoError(t, err)
+		return mo.Events()[offset].Data
+	}
+
+	span := call("10.1.2.3:5000")
+	assert.Equal(t, "abcdef", span["trace.trace_id"])
+	assert.Equal(t, "12345", span["trace.parent_id"])
+
+	span = call("203.0.113.7:5000")
+	assert.NotEqual(t, "abcdef", span["trace.trace_id"], "an untrusted RPC starts a new trace")
+	assert.Nil(t, span["trace.parent_id"])
+	assert.Equal(t, true, span["meta.untrusted_upstream"])
+
+	span = call("203.0.113.7:5000", "x-internal-token", "s3cret")
+	assert.Equal(t, "abcdef", span["trace.trace_id"])
+}
+
+func TestClientStatsHandlerDestinationPolicy(t *testing.T) {
+	mo := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:       "placeholder",
+		Dataset:      "placeholder",
+		APIHost:      "placeholder",
+		Transmission: mo})
+	assert.Equal(t, nil, err)
+	beeline.Init(beeline.Config{Client: client})
+
+	var header []string
+	lis := bufconn.Listen(1 << 20)
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(
+		ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		header = md.Get(propagation.TracePropagationGRPCHeader)
+		return handler(ctx, req)
+	}))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(lis)
+	defer server.Stop()
+
+	// only a stats handler, which isn't told the target, so a policy that
+	// might deny it sends nothing
+	cc, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithStatsHandler(NewClientStatsHandlerWithConfig(config.GRPCOutgoingConfig{
+			DestinationPolicy: config.DestinationPolicy{Deny: []string{"bufnet"}},
+		})),
+	)
+	assert.NoError(t, err)
+	defer cc.Close()
+
+	ctx, parent := beeline.StartSpan(context.Background(), "parent")
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(cc).Check(ctx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	parent.Send()
+
+	assert.Empty(t, header, "no trace context is sent")
+	assert.Eventually(t, func() bool { return len(mo.Events()) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, "none", mo.Events()[0].Data["meta.propagation"])
+}
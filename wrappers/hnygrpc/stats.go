@@ -0,0 +1,238 @@
+package hnygrpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/beeline-go/trace"
+	"github.com/honeycombio/beeline-go/wrappers/common"
+	"github.com/honeycombio/beeline-go/wrappers/config"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+// rpcStatsKey is the context key for the *rpcStats of an RPC.
+type rpcStatsKey struct{}
+
+// rpcStats is the state shared by the stats handlers and interceptors for a
+// single RPC. Whichever of them creates the span owns it and sends it; the
+// other adds its fields to the same span.
+type rpcStats struct {
+	span   *trace.Span
+	client bool
+	// owned is true if the stats handler created the span, and so must send
+	// it when the RPC ends.
+	owned bool
+
+	mu            sync.Mutex
+	begin         time.Time
+	firstByte     bool
+	requestBytes  int
+	requestWire   int
+	responseBytes int
+	responseWire  int
+}
+
+func rpcStatsFromContext(ctx context.Context) *rpcStats {
+	st, _ := ctx.Value(rpcStatsKey{}).(*rpcStats)
+	return st
+}
+
+// serverSpanFromStats returns the span a server stats handler created for the
+// RPC in ctx, if there is one, so the server interceptors can use it rather
+// than starting their own.
+func serverSpanFromStats(ctx context.Context) *trace.Span {
+	if st := rpcStatsFromContext(ctx); st != nil && !st.client {
+		return st.span
+	}
+	return nil
+}
+
+// withClientStats returns a copy of ctx marking span as the span of the client
+// RPC about to be made, so a client stats handler adds its fields to it.
+func withClientStats(ctx context.Context, span *trace.Span) context.Context {
+	return context.WithValue(ctx, rpcStatsKey{}, &rpcStats{span: span, client: true})
+}
+
+// markFirstByte records the time to first byte the first time it is called.
+// The caller must hold mu.
+func (st *rpcStats) markFirstByte() {
+	if st.firstByte || st.begin.IsZero() {
+		return
+	}
+	st.firstByte = true
+	st.span.AddField("grpc.time_to_first_byte_ms", float64(time.Since(st.begin))/float64(time.Millisecond))
+}
+
+// handle adds the information in an RPC stats event to the span. Requests are
+// the inbound payloads on servers and the outbound payloads on clients.
+func (st *rpcStats) handle(ctx context.Context, rs stats.RPCStats) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	switch s := rs.(type) {
+	case *stats.Begin:
+		st.begin = s.BeginTime
+		if deadline, ok := ctx.Deadline(); ok {
+			st.span.AddField("grpc.deadline_remaining_ms", float64(deadline.Sub(s.BeginTime))/float64(time.Millisecond))
+		}
+	case *stats.InHeader:
+		if st.client {
+			st.markFirstByte()
+		} else if s.Compression != "" {
+			st.span.AddField("grpc.compression", s.Compression)
+		}
+	case *stats.OutHeader:
+		if !st.client {
+			st.markFirstByte()
+			break
+		}
+		if s.Compression != "" {
+			st.span.AddField("grpc.compression", s.Compression)
+		}
+		if s.RemoteAddr != nil {
+			st.span.AddField("grpc.peer.address", s.RemoteAddr.String())
+		}
+	case *stats.InPayload:
+		if st.client {
+			st.markFirstByte()
+			st.responseBytes += s.Length
+			st.responseWire += s.WireLength
+		} else {
+			st.requestBytes += s.Length
+			st.requestWire += s.WireLength
+		}
+	case *stats.OutPayload:
+		if st.client {
+			st.requestBytes += s.Length
+			st.requestWire += s.WireLength
+		} else {
+			st.markFirstByte()
+			st.responseBytes += s.Length
+			st.responseWire += s.WireLength
+		}
+	case *stats.End:
+		code := status.Code(s.Error)
+		st.span.AddFields(map[string]interface{}{
+			"grpc.status_code":         int(code),
+			"grpc.code":                code.String(),
+			"grpc.deadline_exceeded":   code == codes.DeadlineExceeded,
+			"grpc.request.bytes":       st.requestBytes,
+			"grpc.request.wire_bytes":  st.requestWire,
+			"grpc.response.bytes":      st.responseBytes,
+			"grpc.response.wire_bytes": st.responseWire,
+		})
+		if st.owned {
+			if s.Error != nil {
+				st.span.AddField("error", s.Error.Error())
+			}
+			st.span.Send()
+		}
+	}
+}
+
+type serverStatsHandler struct {
+	cfg config.GRPCIncomingConfig
+}
+
+// NewServerStatsHandlerWithConfig returns a stats.Handler that records the
+// status, payload sizes, compression, time to first byte and deadline of each
+// RPC handled by a server. Register it with grpc.StatsHandler.
+//
+// The stats handler creates the span for each RPC, reading trace context from
+// the metadata as the interceptors do, and sends it once the response has
+// been written. When the server interceptors are also installed they add
+// their fields to that same span rather than creating a child. RPCs dropped
+// by the config's Filter are not recorded.
+func NewServerStatsHandlerWithConfig(cfg config.GRPCIncomingConfig) stats.Handler {
+	return &serverStatsHandler{cfg: cfg}
+}
+
+// NewServerStatsHandler is identical to NewServerStatsHandlerWithConfig called
+// with an empty config.GRPCIncomingConfig.
+func NewServerStatsHandler() stats.Handler {
+	return NewServerStatsHandlerWithConfig(config.GRPCIncomingConfig{})
+}
+
+func (h *serverStatsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	// RPCs the filter drops are passed on untraced
+	if untraced, traced := filterGRPC(ctx, info.FullMethodName, h.cfg); !traced {
+		return untraced
+	}
+	ctx, span := startSpanOrTraceFromGRPC(ctx, h.cfg)
+	span.AddField("name", info.FullMethodName)
+	span.AddField("meta.type", "grpc_request")
+	span.AddField("handler.method", info.FullMethodName)
+	addRequestFields(ctx, span)
+	return context.WithValue(ctx, rpcStatsKey{}, &rpcStats{span: span, owned: true})
+}
+
+func (h *serverStatsHandler) HandleRPC(ctx context.Context, rs stats.RPCStats) {
+	if st := rpcStatsFromContext(ctx); st != nil && !st.client {
+		st.handle(ctx, rs)
+	}
+}
+
+func (h *serverStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *serverStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+type clientStatsHandler struct {
+	cfg config.GRPCOutgoingConfig
+}
+
+// NewClientStatsHandlerWithConfig returns a stats.Handler that records the
+// status, payload sizes, compression, time to first byte, deadline and peer
+// address of each RPC made by a client. Register it with
+// grpc.WithStatsHandler.
+//
+// When the client interceptors are also installed, the fields are added to
+// the span they create. Otherwise the stats handler creates a child of the
+// span in the call's context, serializing the trace propagation context into
+// the metadata as the interceptors do, and sends it when the RPC ends. Calls
+// made without a span in their context are not recorded.
+func NewClientStatsHandlerWithConfig(cfg config.GRPCOutgoingConfig) stats.Handler {
+	return &clientStatsHandler{cfg: cfg}
+}
+
+// NewClientStatsHandler is identical to NewClientStatsHandlerWithConfig called
+// with an empty config.GRPCOutgoingConfig.
+func NewClientStatsHandler() stats.Handler {
+	return NewClientStatsHandlerWithConfig(config.GRPCOutgoingConfig{})
+}
+
+func (h *clientStatsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	if st := rpcStatsFromContext(ctx); st != nil && st.client {
+		// the client interceptor created the span
+		return ctx
+	}
+	span := trace.GetSpanFromContext(ctx)
+	if span == nil {
+		return ctx
+	}
+	ctx, span = span.CreateChild(ctx)
+	span.AddField("name", info.FullMethodName)
+	span.AddField("meta.type", "grpc_client")
+	// the target of the RPC isn't known here
+	ctx, level := injectMetadata(ctx, span.PropagationContext(), h.cfg, "")
+	if level != common.PropagateFull {
+		span.AddField("meta.propagation", level.String())
+	}
+	return context.WithValue(ctx, rpcStatsKey{}, &rpcStats{span: span, client: true, owned: true})
+}
+
+func (h *clientStatsHandler) HandleRPC(ctx context.Context, rs stats.RPCStats) {
+	if st := rpcStatsFromContext(ctx); st != nil && st.client {
+		st.handle(ctx, rs)
+	}
+}
+
+func (h *clientStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *clientStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
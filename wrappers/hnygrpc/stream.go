@@ -0,0 +1,311 @@
+package hnygrpc
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/honeycombio/beeline-go/timer"
+	"github.com/honeycombio/beeline-go/trace"
+	"github.com/honeycombio/beeline-go/wrappers/common"
+	"github.com/honeycombio/beeline-go/wrappers/config"
+	"github.com/honeycombio/libhoney-go"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// messageCounter counts the messages sent and received on a stream, adding a
+// span event for each one if events are enabled. SendMsg and RecvMsg may be
+// called from different goroutines, so the counts are atomic.
+type messageCounter struct {
+	span     *trace.Span
+	events   bool
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+func (mc *messageCounter) countSent() {
+	n := mc.sent.Add(1)
+	if mc.events && mc.span != nil {
+		mc.span.AddSpanEvent("message", map[string]interface{}{
+			"message.type": "SENT",
+			"message.id":   n,
+		})
+	}
+}
+
+func (mc *messageCounter) countReceived() {
+	n := mc.received.Add(1)
+	if mc.events && mc.span != nil {
+		mc.span.AddSpanEvent("message", map[string]interface{}{
+			"message.type": "RECEIVED",
+			"message.id":   n,
+		})
+	}
+}
+
+// addStreamFields adds available information about a streaming gRPC request
+// to the provided span.
+func addStreamFields(ctx context.Context, info *grpc.StreamServerInfo, handler grpc.StreamHandler, span *trace.Span) {
+	handlerName := runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name()
+
+	span.AddField("name", handlerName)
+	span.AddField("meta.type", "grpc_request")
+	span.AddField("handler.name", handlerName)
+	span.AddField("handler.method", info.FullMethod)
+	span.AddField("handler.client_stream", info.IsClientStream)
+	span.AddField("handler.server_stream", info.IsServerStream)
+	addRequestFields(ctx, span)
+}
+
+// serverStream wraps a grpc.ServerStream so that its context carries the
+// stream's span and its messages are counted.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+	messageCounter
+}
+
+// Context returns the context of the stream, which carries its span so that
+// handlers can create child spans.
+func (ss *serverStream) Context() context.Context {
+	return ss.ctx
+}
+
+func (ss *serverStream) SendMsg(m interface{}) error {
+	err := ss.ServerStream.SendMsg(m)
+	if err == nil {
+		ss.countSent()
+	}
+	return err
+}
+
+func (ss *serverStream) RecvMsg(m interface{}) error {
+	err := ss.ServerStream.RecvMsg(m)
+	if err == nil {
+		ss.countReceived()
+	}
+	return err
+}
+
+// StreamServerInterceptorWithConfig will create a Honeycomb span for the
+// lifetime of each stream handled by the returned interceptor. Trace context is
+// read from the gRPC metadata as it is by UnaryServerInterceptorWithConfig,
+// and the stream passed to the handler returns a context carrying the span
+// from its Context method.
+//
+// The span counts the messages sent and received on the stream and records
+// the status the handler returned. If the config has MessageEvents set, a span
+// event is also added for each message. Streams dropped by the config's
+// Filter are passed to the handler untraced.
+func StreamServerInterceptorWithConfig(cfg config.GRPCIncomingConfig) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx := ss.Context()
+		// streams the filter drops are passed on untraced
+		if untraced, traced := filterGRPC(ctx, info.FullMethod, cfg); !traced {
+			return handler(srv, &serverStream{ServerStream: ss, ctx: untraced})
+		}
+		span := serverSpanFromStats(ctx)
+		if span == nil {
+			ctx, span = startSpanOrTraceFromGRPC(ctx, cfg)
+			defer span.Send()
+		}
+
+		addStreamFields(ctx, info, handler, span)
+		wrapped := &serverStream{
+			ServerStream:   ss,
+			ctx:            ctx,
+			messageCounter: messageCounter{span: span, events: cfg.MessageEvents},
+		}
+		err := handler(srv, wrapped)
+		if err != nil {
+			span.AddTraceField("handler_error", err.Error())
+		}
+		code := status.Code(err)
+		span.AddField("response.grpc_status_code", code)
+		span.AddField("response.grpc_status_message", code.String())
+		span.AddField("stream.messages_sent", wrapped.sent.Load())
+		span.AddField("stream.messages_received", wrapped.received.Load())
+		return err
+	}
+}
+
+// StreamServerInterceptor is identical to StreamServerInterceptorWithConfig
+// called with an empty config.GRPCIncomingConfig.
+func StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return StreamServerInterceptorWithConfig(config.GRPCIncomingConfig{})
+}
+
+// clientStream wraps a grpc.ClientStream, counting its messages and sending
+// its span or event once the stream has finished.
+type clientStream struct {
+	grpc.ClientStream
+	desc *grpc.StreamDesc
+	messageCounter
+
+	addField   func(string, interface{})
+	send       func()
+	finishOnce sync.Once
+	// stopAfterCtx stops finishing the stream when its context is done
+	stopAfterCtx func() bool
+}
+
+func (cs *clientStream) SendMsg(m interface{}) error {
+	err := cs.ClientStream.SendMsg(m)
+	if err == nil {
+		cs.countSent()
+	} else if err != io.EOF {
+		// io.EOF means the stream was ended by the server; the status is
+		// returned by RecvMsg.
+		cs.finish(err)
+	}
+	return err
+}
+
+func (cs *clientStream) RecvMsg(m interface{}) error {
+	err := cs.ClientStream.RecvMsg(m)
+	switch {
+	case err == nil:
+		cs.countReceived()
+		if !cs.desc.ServerStreams {
+			// the server sends a single response on streams that are not
+			// server streaming, so the stream is done
+			cs.finish(nil)
+		}
+	case err == io.EOF:
+		cs.finish(nil)
+	default:
+		cs.finish(err)
+	}
+	return err
+}
+
+func (cs *clientStream) CloseSend() error {
+	err := cs.ClientStream.CloseSend()
+	if err != nil {
+		cs.finish(err)
+	}
+	return err
+}
+
+func (cs *clientStream) Header() (metadata.MD, error) {
+	md, err := cs.ClientStream.Header()
+	if err != nil {
+		cs.finish(err)
+	}
+	return md, err
+}
+
+// finish records the outcome of the stream and sends its span or event. Only
+// the first call has any effect.
+func (cs *clientStream) finish(err error) {
+	cs.finishOnce.Do(func() {
+		if cs.stopAfterCtx != nil {
+			cs.stopAfterCtx()
+		}
+		if err != nil {
+			cs.addField("error", err.Error())
+		}
+		code := status.Code(err)
+		cs.addField("response.grpc_status_code", code)
+		cs.addField("response.grpc_status_message", code.String())
+		cs.addField("stream.messages_sent", cs.sent.Load())
+		cs.addField("stream.messages_received", cs.received.Load())
+		cs.send()
+	})
+}
+
+// StreamClientInterceptorWithConfig will create a Honeycomb span for the
+// lifetime of each stream opened through the returned interceptor, and
+// serialize the trace propagation context into the gRPC metadata as
+// UnaryClientInterceptorWithConfig does. If there's no active trace or span,
+// an event is sent instead.
+//
+// The span is sent when the stream finishes: when RecvMsg returns io.EOF or an
+// error, when a stream that is not server streaming has received its
+// response, when SendMsg, CloseSend or Header fail, or when the stream's
+// context is done. It is an asynchronous child of the active span, so it is
+// still sent in full if that span is sent first. It counts the messages sent
+// and received and records the final status. If the config has MessageEvents
+// set, a span event is also added for each message.
+func StreamClientInterceptorWithConfig(cfg config.GRPCOutgoingConfig) grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		wrapped := &clientStream{desc: desc}
+
+		// how much trace context is sent to the target
+		level := common.PropagateFull
+		span := trace.GetSpanFromContext(ctx)
+		if span == nil {
+			// If there's no active trace or span, just send an event.
+			tm := timer.Start()
+			ev := libhoney.NewEvent()
+			wrapped.addField = ev.AddField
+			wrapped.send = func() {
+				ev.AddField("duration_ms", tm.Finish())
+				ev.Send()
+			}
+			// pass on the trace of an untraced incoming request, if there is one
+			if prop, truncated := trace.GetOutgoingPropagationContextFromContext(ctx); prop != nil {
+				if truncated {
+					ev.AddField("meta.propagation_truncated", true)
+				}
+				ctx, level = injectMetadata(ctx, prop, cfg, cc.Target())
+			}
+		} else {
+			// the stream may outlive the span that opened it
+			ctx, span = span.CreateAsyncChild(ctx)
+			ctx, level = injectMetadata(ctx, span.PropagationContext(), cfg, cc.Target())
+			ctx = withClientStats(ctx, span)
+			wrapped.span = span
+			wrapped.events = cfg.MessageEvents
+			wrapped.addField = span.AddField
+			wrapped.send = span.Send
+		}
+		wrapped.addField("name", method)
+		wrapped.addField("meta.type", "grpc_client")
+		wrapped.addField("request.target", cc.Target())
+		wrapped.addField("request.client_stream", desc.ClientStreams)
+		wrapped.addField("request.server_stream", desc.ServerStreams)
+		if level != common.PropagateFull {
+			wrapped.addField("meta.propagation", level.String())
+		}
+
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			wrapped.finish(err)
+			return nil, err
+		}
+		wrapped.ClientStream = cs
+
+		// make sure the span is sent if the caller abandons the stream by
+		// cancelling its context. Nothing waits on contexts that can't be
+		// cancelled.
+		wrapped.stopAfterCtx = context.AfterFunc(ctx, func() {
+			wrapped.finish(status.FromContextError(ctx.Err()).Err())
+		})
+		return wrapped, nil
+	}
+}
+
+// StreamClientInterceptor is identical to StreamClientInterceptorWithConfig
+// called with an empty config.GRPCOutgoingConfig.
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return StreamClientInterceptorWithConfig(config.GRPCOutgoingConfig{})
+}
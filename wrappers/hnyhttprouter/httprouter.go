@@ -6,21 +6,36 @@ import (
 	"runtime"
 
 	"github.com/honeycombio/beeline-go/wrappers/common"
+	"github.com/honeycombio/beeline-go/wrappers/config"
 	"github.com/julienschmidt/httprouter"
 )
 
 // Middleware wraps httprouter handlers. Since it wraps handlers with explicit
 // parameters, it can add those values to the event it generates.
 func Middleware(handle httprouter.Handle) httprouter.Handle {
+	return MiddlewareWithConfig(handle, config.HTTPIncomingConfig{})
+}
+
+// MiddlewareWithConfig is a version of Middleware that uses the parser hook,
+// header and body capture, filter and sample rate settings of cfg.
+func MiddlewareWithConfig(handle httprouter.Handle, cfg config.HTTPIncomingConfig) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		// requests the filter drops are passed on untraced
+		if untraced, traced := common.FilterHTTPRequest(r, cfg); !traced {
+			handle(w, untraced, ps)
+			return
+		}
 		// get a new context with our trace from the request, and add common fields
-		ctx, span := common.StartSpanOrTraceFromHTTP(r)
+		ctx, span := common.StartSpanOrTraceFromHTTPWithConfig(r, cfg)
 		defer span.Send()
 		// push the context with our trace and span on to the request
 		r = r.WithContext(ctx)
 
 		// replace the writer with our wrapper to catch the status code
 		wrappedWriter := common.NewResponseWriter(w)
+		// record the bodies, if they are captured
+		bodyCapture := common.NewBodyCapture(cfg.BodyCapture)
+		bodyCapture.CaptureRequestBody(r)
 
 		// pull out any variables in the URL, add the thing we're matching, etc.
 		for _, param := range ps {
@@ -30,11 +45,13 @@ func Middleware(handle httprouter.Handle) httprouter.Handle {
 		span.AddField("handler.name", name)
 		span.AddField("name", name)
 
-		handle(wrappedWriter.Wrapped, r, ps)
+		handle(bodyCapture.WrapResponseWriter(wrappedWriter.Wrapped), r, ps)
 
 		if wrappedWriter.Status == 0 {
 			wrappedWriter.Status = 200
 		}
 		span.AddField("response.status_code", wrappedWriter.Status)
+		span.AddFields(common.GetHeaderProps(wrappedWriter.Wrapped.Header(), "response.header.", cfg.HeaderCapture))
+		span.AddFields(bodyCapture.Fields())
 	}
 }
@@ -0,0 +1,79 @@
+package hnynethttp
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/honeycombio/beeline-go/trace"
+	"github.com/honeycombio/beeline-go/wrappers/common"
+)
+
+const defaultResponseBodyTimeout = 5 * time.Minute
+
+// tracedBody wraps a response body so that the client span is sent once the
+// body has been read to EOF, has failed, or has been closed. A timer sends the
+// span if none of those happen in time. Any captured bodies are added to the
+// span before it is sent.
+type tracedBody struct {
+	rc      io.ReadCloser
+	span    *trace.Span
+	capture *common.BodyCapture
+	start   time.Time
+	bytes   atomic.Int64
+	timer   *time.Timer
+	once    sync.Once
+}
+
+func newTracedBody(rc io.ReadCloser, span *trace.Span, capture *common.BodyCapture, timeout time.Duration) *tracedBody {
+	if timeout <= 0 {
+		timeout = defaultResponseBodyTimeout
+	}
+	b := &tracedBody{
+		rc:      rc,
+		span:    span,
+		capture: capture,
+		start:   time.Now(),
+	}
+	b.timer = time.AfterFunc(timeout, func() {
+		b.finish(nil, true)
+	})
+	return b
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.rc.Read(p)
+	b.bytes.Add(int64(n))
+	switch {
+	case err == io.EOF:
+		b.finish(nil, false)
+	case err != nil:
+		b.finish(err, false)
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	err := b.rc.Close()
+	b.finish(nil, false)
+	return err
+}
+
+// finish records how the body was read and sends the span. Only the first call
+// has any effect.
+func (b *tracedBody) finish(err error, timedOut bool) {
+	b.once.Do(func() {
+		b.timer.Stop()
+		b.span.AddField("response.body_bytes", b.bytes.Load())
+		b.span.AddField("response.body_read_ms", float64(time.Since(b.start))/float64(time.Millisecond))
+		if err != nil {
+			b.span.AddField("response.body_error", err.Error())
+		}
+		if timedOut {
+			b.span.AddField("response.body_timed_out", true)
+		}
+		b.span.AddFields(b.capture.Fields())
+		b.span.Send()
+	})
+}
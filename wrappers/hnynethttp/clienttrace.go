@@ -0,0 +1,144 @@
+package hnynethttp
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/beeline-go/trace"
+	"github.com/honeycombio/beeline-go/wrappers/config"
+)
+
+// clientTrace records the phases of an outgoing request on its span. The
+// httptrace hooks may be called concurrently, for example when dialing
+// several addresses at once, so its state is guarded by a mutex.
+type clientTrace struct {
+	ctx  context.Context
+	span *trace.Span
+	mode config.HTTPClientTraceMode
+
+	mu       sync.Mutex
+	starts   map[string]time.Time
+	children map[string]*trace.Span
+}
+
+func newClientTrace(ctx context.Context, span *trace.Span, mode config.HTTPClientTraceMode) *clientTrace {
+	return &clientTrace{
+		ctx:      ctx,
+		span:     span,
+		mode:     mode,
+		starts:   make(map[string]time.Time),
+		children: make(map[string]*trace.Span),
+	}
+}
+
+// start marks the beginning of a phase. key identifies this instance of the
+// phase, as a request can, for example, try connecting to more than one
+// address.
+func (ct *clientTrace) start(phase, key string) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	ct.starts[key] = time.Now()
+	if ct.mode == config.HTTPClientTraceSpans {
+		_, child := ct.span.CreateChild(ct.ctx)
+		child.AddField("name", "http."+phase)
+		child.AddField("meta.type", "http_client_phase")
+		ct.children[key] = child
+	}
+}
+
+// end records the duration of a phase that was started, along with any fields
+// describing it and the error it ended with.
+func (ct *clientTrace) end(phase, key string, fields map[string]interface{}, err error) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	start, ok := ct.starts[key]
+	if !ok {
+		return
+	}
+	delete(ct.starts, key)
+	dur := float64(time.Since(start)) / float64(time.Millisecond)
+
+	ct.span.AddField("http."+phase+"_ms", dur)
+	if err != nil {
+		ct.span.AddField("http."+phase+"_error", err.Error())
+	}
+	ct.span.AddFields(fields)
+
+	switch ct.mode {
+	case config.HTTPClientTraceEvents:
+		eventFields := map[string]interface{}{"duration_ms": dur}
+		for k, v := range fields {
+			eventFields[k] = v
+		}
+		if err != nil {
+			eventFields["error"] = err.Error()
+		}
+		ct.span.AddSpanEvent("http."+phase, eventFields)
+	case config.HTTPClientTraceSpans:
+		child := ct.children[key]
+		delete(ct.children, key)
+		child.AddFields(fields)
+		if err != nil {
+			child.AddField("error", err.Error())
+		}
+		child.Send()
+	}
+}
+
+// hooks returns the httptrace hooks recording the phases of the request.
+func (ct *clientTrace) hooks() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(string) {
+			ct.start("get_conn", "get_conn")
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			fields := map[string]interface{}{
+				"conn.reused":   info.Reused,
+				"conn.was_idle": info.WasIdle,
+			}
+			if info.WasIdle {
+				fields["conn.idle_time_ms"] = float64(info.IdleTime) / float64(time.Millisecond)
+			}
+			if info.Conn != nil {
+				if addr := info.Conn.RemoteAddr(); addr != nil {
+					host, _, err := net.SplitHostPort(addr.String())
+					if err != nil {
+						host = addr.String()
+					}
+					fields["conn.remote_ip"] = host
+				}
+			}
+			ct.end("get_conn", "get_conn", fields, nil)
+			ct.start("write_request", "write_request")
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			ct.start("dns", "dns")
+		},
+		DNSDone: func(info httptrace.DNSDoneInfo) {
+			ct.end("dns", "dns", nil, info.Err)
+		},
+		ConnectStart: func(network, addr string) {
+			ct.start("connect", "connect "+addr)
+		},
+		ConnectDone: func(network, addr string, err error) {
+			ct.end("connect", "connect "+addr, nil, err)
+		},
+		TLSHandshakeStart: func() {
+			ct.start("tls_handshake", "tls_handshake")
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			ct.end("tls_handshake", "tls_handshake", nil, err)
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			ct.end("write_request", "write_request", nil, info.Err)
+			ct.start("time_to_first_byte", "time_to_first_byte")
+		},
+		GotFirstResponseByte: func() {
+			ct.end("time_to_first_byte", "time_to_first_byte", nil, nil)
+		},
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/http/httptrace"
 	"reflect"
 	"runtime"
 	"strings"
+	"time"
 
 	"github.com/honeycombio/beeline-go/propagation"
 	"github.com/honeycombio/beeline-go/timer"
@@ -20,25 +22,37 @@ import (
 // of this handler with all the standard HTTP fields attached. If passed a
 // ServeMux instead, pull what you can from there. The provided config has a
 // HTTPTraceParserHook, it will be invoked when creating a new span or trace for
-// each incoming HTTP request.
+// each incoming HTTP request. Request and response headers selected by its
+// HeaderCapture, and bodies selected by its BodyCapture, are added to the
+// span. Requests its Filter drops are passed to the handler untraced, and
+// its SampleRate sets the sample rate of the traces requests start.
+//
+// When a ServeMux routes the request, the span is named after the method and
+// the pattern that matched, eg "GET /items/{id}", and gets a `route` field
+// and a `handler.vars.<name>` field for each of the pattern's wildcards. If
+// the request is routed on by a nested mux, or through http.StripPrefix, to a
+// handler that is wrapped too, the span gets the innermost pattern.
 func WrapHandlerWithConfig(handler http.Handler, cfg config.HTTPIncomingConfig) http.Handler {
 	// if we can cache handlerName here, let's do so for efficiency's sake
 	handlerName := getHandlerName(handler)
 
 	wrappedHandler := func(w http.ResponseWriter, r *http.Request) {
-		// get a new context with our trace from the request, and add common fields
-		var ctx context.Context
-		var span *trace.Span
-		if cfg.HTTPParserHook == nil {
-			ctx, span = common.StartSpanOrTraceFromHTTP(r)
-		} else {
-			ctx, span = common.StartSpanOrTraceFromHTTPWithTraceParserHook(r, cfg.HTTPParserHook)
+		// requests the filter drops are passed on untraced
+		if untraced, traced := common.FilterHTTPRequest(r, cfg); !traced {
+			handler.ServeHTTP(w, untraced)
+			return
 		}
+		// get a new context with our trace from the request, and add common fields
+		ctx, span := common.StartSpanOrTraceFromHTTPWithConfig(r, cfg)
 		defer span.Send()
+		ctx, rt := withRoute(ctx)
 		// push the context with our trace and span on to the request
 		r = r.WithContext(ctx)
 		// replace the writer with our wrapper to catch the status code
 		wrappedWriter := common.NewResponseWriter(w)
+		// record the bodies, if they are captured
+		bodyCapture := common.NewBodyCapture(cfg.BodyCapture)
+		bodyCapture.CaptureRequestBody(r)
 
 		mux, ok := handler.(*http.ServeMux)
 		if ok {
@@ -62,7 +76,9 @@ func WrapHandlerWithConfig(handler http.Handler, cfg config.HTTPIncomingConfig)
 			}
 		}
 
-		handler.ServeHTTP(wrappedWriter.Wrapped, r)
+		handler.ServeHTTP(bodyCapture.WrapResponseWriter(wrappedWriter.Wrapped), r)
+		rt.record(r)
+		rt.addFields(span)
 		if wrappedWriter.Status == 0 {
 			wrappedWriter.Status = 200
 		}
@@ -76,10 +92,82 @@ func WrapHandlerWithConfig(handler http.Handler, cfg config.HTTPIncomingConfig)
 			span.AddField("response.content_encoding", ce)
 		}
 		span.AddField("response.status_code", wrappedWriter.Status)
+		span.AddFields(common.GetHeaderProps(wrappedWriter.Wrapped.Header(), "response.header.", cfg.HeaderCapture))
+		span.AddFields(bodyCapture.Fields())
 	}
 	return http.HandlerFunc(wrappedHandler)
 }
 
+type routeKey struct{}
+
+// route is the ServeMux pattern that matched a request, and the values of its
+// wildcards. It is shared through the request context, so that the wrappers
+// of a handler and of the mux that routes to it report the same route. A
+// ServeMux sets the pattern on the request it is given, which may be a copy,
+// such as those made by http.StripPrefix, that outer wrappers never see.
+type route struct {
+	method  string
+	pattern string
+	vars    map[string]string
+}
+
+// withRoute returns the route of the request ctx belongs to, adding one to
+// ctx if an outer wrapper hasn't already.
+func withRoute(ctx context.Context) (context.Context, *route) {
+	if rt, ok := ctx.Value(routeKey{}).(*route); ok {
+		return ctx, rt
+	}
+	rt := &route{}
+	return context.WithValue(ctx, routeKey{}, rt), rt
+}
+
+// record sets the route from r, once its handler has returned, unless a
+// wrapper nested deeper in the handler already has. The innermost pattern is
+// the most specific.
+func (rt *route) record(r *http.Request) {
+	if rt.pattern != "" || r.Pattern == "" {
+		return
+	}
+	rt.method = r.Method
+	rt.pattern = r.Pattern
+	for _, segment := range strings.Split(rt.path(), "/") {
+		if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") {
+			continue
+		}
+		name := strings.TrimSuffix(segment[1:len(segment)-1], "...")
+		if name == "$" {
+			continue
+		}
+		if rt.vars == nil {
+			rt.vars = make(map[string]string)
+		}
+		rt.vars[name] = r.PathValue(name)
+	}
+}
+
+// path returns the pattern without its method, which may not be the one the
+// request used if a GET pattern matched a HEAD request.
+func (rt *route) path() string {
+	if i := strings.IndexAny(rt.pattern, " \t"); i >= 0 {
+		return strings.TrimLeft(rt.pattern[i:], " \t")
+	}
+	return rt.pattern
+}
+
+// addFields names the span after the route, if any, and adds the pattern's
+// wildcards as fields.
+func (rt *route) addFields(span *trace.Span) {
+	if rt.pattern == "" {
+		return
+	}
+	span.AddField("name", rt.method+" "+rt.path())
+	span.AddField("route", rt.path())
+	span.AddField("handler.pattern", rt.pattern)
+	for name, value := range rt.vars {
+		span.AddField("handler.vars."+name, value)
+	}
+}
+
 // getHandlerName returns the name of the function or struct passed to it
 func getHandlerName(handler interface{}) string {
 	voh := reflect.ValueOf(handler)
@@ -91,30 +179,50 @@ func getHandlerName(handler interface{}) string {
 
 // WrapHandler will create a Honeycomb event per invocation of this handler with
 // all the standard HTTP fields attached. If passed a ServeMux instead, pull
-// what you can from there
+// what you can from there, naming the span after the pattern that matched
+// the request.
 func WrapHandler(handler http.Handler) http.Handler {
 	return WrapHandlerWithConfig(handler, config.HTTPIncomingConfig{})
 }
 
 // WrapHandlerFunc will create a Honeycomb event per invocation of this handler
-// function with all the standard HTTP fields attached.
+// function with all the standard HTTP fields attached. If it is registered
+// with a ServeMux, the span is named after the pattern that matched the
+// request.
 func WrapHandlerFunc(hf func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return WrapHandlerFuncWithConfig(hf, config.HTTPIncomingConfig{})
+}
+
+// WrapHandlerFuncWithConfig is a version of WrapHandlerFunc that accepts a
+// config, used in the same way as by WrapHandlerWithConfig.
+func WrapHandlerFuncWithConfig(hf func(http.ResponseWriter, *http.Request), cfg config.HTTPIncomingConfig) func(http.ResponseWriter, *http.Request) {
 	handlerFuncName := runtime.FuncForPC(reflect.ValueOf(hf).Pointer()).Name()
 	return func(w http.ResponseWriter, r *http.Request) {
+		// requests the filter drops are passed on untraced
+		if untraced, traced := common.FilterHTTPRequest(r, cfg); !traced {
+			hf(w, untraced)
+			return
+		}
 		// get a new context with our trace from the request, and add common fields
-		ctx, span := common.StartSpanOrTraceFromHTTP(r)
+		ctx, span := common.StartSpanOrTraceFromHTTPWithConfig(r, cfg)
 		defer span.Send()
+		ctx, rt := withRoute(ctx)
 		// push the context with our trace and span on to the request
 		r = r.WithContext(ctx)
 		// replace the writer with our wrapper to catch the status code
 		wrappedWriter := common.NewResponseWriter(w)
+		// record the bodies, if they are captured
+		bodyCapture := common.NewBodyCapture(cfg.BodyCapture)
+		bodyCapture.CaptureRequestBody(r)
 		// add the name of the handler func we're about to invoke
 		if handlerFuncName != "" {
 			span.AddField("handler_func_name", handlerFuncName)
 			span.AddField("name", handlerFuncName)
 		}
 
-		hf(wrappedWriter.Wrapped, r)
+		hf(bodyCapture.WrapResponseWriter(wrappedWriter.Wrapped), r)
+		rt.record(r)
+		rt.addFields(span)
 		if wrappedWriter.Status == 0 {
 			wrappedWriter.Status = 200
 		}
@@ -128,6 +236,8 @@ func WrapHandlerFunc(hf func(http.ResponseWriter, *http.Request)) func(http.Resp
 			span.AddField("response.content_encoding", ce)
 		}
 		span.AddField("response.status_code", wrappedWriter.Status)
+		span.AddFields(common.GetHeaderProps(wrappedWriter.Wrapped.Header(), "response.header.", cfg.HeaderCapture))
+		span.AddFields(bodyCapture.Fields())
 	}
 }
 
@@ -135,6 +245,12 @@ type hnyTripper struct {
 	// wrt is the wrapped round tripper
 	wrt             http.RoundTripper
 	propagationHook config.HTTPTracePropagationHook
+	policy          config.DestinationPolicy
+	headerCapture   config.HTTPHeaderCapture
+	clientTrace     config.HTTPClientTraceMode
+	traceBody       bool
+	bodyTimeout     time.Duration
+	bodyCapture     config.HTTPBodyCapture
 }
 
 func (ht *hnyTripper) RoundTrip(r *http.Request) (*http.Response, error) {
@@ -158,12 +274,24 @@ func (ht *hnyTripper) eventRoundTrip(r *http.Request) (*http.Response, error) {
 	}
 
 	ev.AddField("meta.type", "http_client")
+	ev.Add(common.GetHeaderProps(r.Header, "request.header.", ht.headerCapture))
+	// pass on the trace of an untraced incoming request, if there is one
+	if prop, truncated := trace.GetOutgoingPropagationContextFromContext(r.Context()); prop != nil {
+		if truncated {
+			ev.AddField("meta.propagation_truncated", true)
+		}
+		if level := ht.inject(r, prop); level != common.PropagateFull {
+			ev.AddField("meta.propagation", level.String())
+		}
+	}
 
 	resp, err := ht.wrt.RoundTrip(r)
 
 	if err != nil {
 		// TODO should this error field be namespaced somehow
 		ev.AddField("error", err.Error())
+	} else {
+		ev.Add(common.GetHeaderProps(resp.Header, "response.header.", ht.headerCapture))
 	}
 	dur := tm.Finish()
 	ev.AddField("duration_ms", dur)
@@ -171,29 +299,80 @@ func (ht *hnyTripper) eventRoundTrip(r *http.Request) (*http.Response, error) {
 
 }
 
+// inject adds as much of the trace context prop to the headers of r as the
+// destination policy allows, returning how much that was. If no propagation
+// hook is defined, it uses the global propagator, or defaults to using the
+// Honeycomb header format.
+func (ht *hnyTripper) inject(r *http.Request, prop *propagation.PropagationContext) common.PropagationLevel {
+	port := r.URL.Port()
+	if port == "" {
+		switch r.URL.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		}
+	}
+	level := common.DestinationPropagation(ht.policy, r.URL.Hostname(), port)
+	prop = common.LimitPropagationContext(prop, level)
+	if prop == nil {
+		return level
+	}
+
+	if ht.propagationHook == nil && propagation.GlobalConfig.Propagator != nil {
+		propagation.GlobalConfig.Propagator.Inject(prop, propagation.HeaderCarrier(r.Header))
+	} else if ht.propagationHook == nil {
+		r.Header.Add(propagation.TracePropagationHTTPHeader, propagation.MarshalHoneycombTraceContext(prop))
+		if prop.Baggage.Len() > 0 {
+			r.Header.Set(propagation.BaggageHeader, prop.Baggage.String())
+		}
+	} else {
+		// if a propagationHook exists, call it to get a map of headers to
+		// inject in the outgoing request.
+		headers := ht.propagationHook(r, prop)
+		for header, value := range headers {
+			r.Header.Add(header, value)
+		}
+	}
+	return level
+}
+
 func (ht *hnyTripper) spanRoundTrip(ctx context.Context, span *trace.Span, r *http.Request) (*http.Response, error) {
+	// record the bodies, if they are captured
+	bodyCapture := common.NewBodyCapture(ht.bodyCapture)
 	// we have a trace, let's use it and pass along trace context in addition to
-	// making a span around this HTTP call
-	ctx, span = span.CreateChild(ctx)
-	defer span.Send()
+	// making a span around this HTTP call. If the response body may be
+	// traced, the span can outlive its parent, which mustn't send it early.
+	if ht.traceBody || bodyCapture != nil {
+		ctx, span = span.CreateAsyncChild(ctx)
+	} else {
+		ctx, span = span.CreateChild(ctx)
+	}
+	// the span is sent when the response body is done with instead, if it is
+	// being traced
+	sendSpan := true
+	defer func() {
+		if sendSpan {
+			span.AddFields(bodyCapture.Fields())
+			span.Send()
+		}
+	}()
 
+	if ht.clientTrace != config.HTTPClientTraceOff {
+		ct := newClientTrace(ctx, span, ht.clientTrace)
+		ctx = httptrace.WithClientTrace(ctx, ct.hooks())
+	}
 	r = r.WithContext(ctx)
+	bodyCapture.CaptureRequestBody(r)
 	// add in common request headers.
 	for k, v := range common.GetRequestProps(r) {
 		span.AddField(k, v)
 	}
 	span.AddField("meta.type", "http_client")
 	span.AddField("name", "http_client")
-	// If no propagation hook is defined, default to using the Honeycomb header format.
-	if ht.propagationHook == nil {
-		r.Header.Add(propagation.TracePropagationHTTPHeader, span.SerializeHeaders())
-	} else {
-		// if a propagationHook exists, call it to get a map of headers to
-		// inject in the outgoing request.
-		headers := ht.propagationHook(r, span.PropagationContext())
-		for header, value := range headers {
-			r.Header.Add(header, value)
-		}
+	span.AddFields(common.GetHeaderProps(r.Header, "request.header.", ht.headerCapture))
+	if level := ht.inject(r, span.PropagationContext()); level != common.PropagateFull {
+		span.AddField("meta.propagation", level.String())
 	}
 
 	resp, err := ht.wrt.RoundTrip(r)
@@ -212,6 +391,16 @@ func (ht *hnyTripper) spanRoundTrip(ctx context.Context, span *trace.Span, r *ht
 			span.AddField("response.content_encoding", ce)
 		}
 		span.AddField("response.status_code", resp.StatusCode)
+		span.AddFields(common.GetHeaderProps(resp.Header, "response.header.", ht.headerCapture))
+		// bodies of protocol upgrades are also writers, so leave them as is
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			bodyCapture.CaptureResponseBody(resp)
+			// a captured body has to be read before the span can be sent
+			if (ht.traceBody || bodyCapture.CapturesResponseBody()) && resp.Body != nil && resp.Body != http.NoBody {
+				resp.Body = newTracedBody(resp.Body, span, bodyCapture, ht.bodyTimeout)
+				sendSpan = false
+			}
+		}
 	}
 	return resp, err
 }
@@ -229,9 +418,25 @@ func WrapRoundTripper(r http.RoundTripper) http.RoundTripper {
 // WrapRoundTripperWithConfig is a version of WrapRoundTripper that accepts a config.
 // If the config contains a HTTPTracePropagationHook, it will be invoked on each outgoing
 // HTTP call. The return value, a map of header names to header strings, will be added
-// to the headers of the outgoing request.
+// to the headers of the outgoing request. The ClientTrace mode of the config selects
+// whether and how the timings of DNS lookup, connecting, TLS handshake, waiting for a pooled
+// connection and waiting for the response are recorded on the client span. If
+// TraceResponseBody is set, the span is only sent once the response body has
+// been read or closed. Request and response bodies selected by its BodyCapture
+// are added to the span; bodies of requests made without a span in their
+// context are not captured. Its DestinationPolicy limits the trace context
+// sent to each host; spans of requests sent less than the full context get a
+// `meta.propagation` field of "ids" or "none".
 func WrapRoundTripperWithConfig(r http.RoundTripper, cfg config.HTTPOutgoingConfig) http.RoundTripper {
-	tripper := &hnyTripper{wrt: r}
+	tripper := &hnyTripper{
+		wrt:           r,
+		headerCapture: cfg.HeaderCapture,
+		clientTrace:   cfg.ClientTrace,
+		traceBody:     cfg.TraceResponseBody,
+		bodyTimeout:   cfg.ResponseBodyTimeout,
+		bodyCapture:   cfg.BodyCapture,
+		policy:        cfg.DestinationPolicy,
+	}
 	if cfg.HTTPPropagationHook != nil {
 		tripper.propagationHook = cfg.HTTPPropagationHook
 	}
@@ -1,11 +1,19 @@
 package hnynethttp
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	beeline "github.com/honeycombio/beeline-go"
+	"github.com/honeycombio/beeline-go/propagation"
+	"github.com/honeycombio/beeline-go/sample"
+	"github.com/honeycombio/beeline-go/wrappers/config"
 	libhoney "github.com/honeycombio/libhoney-go"
 	"github.com/honeycombio/libhoney-go/transmission"
 	"github.com/stretchr/testify/assert"
@@ -90,3 +98,606 @@ func TestWrapHandler(t *testing.T) {
 	assert.True(t, ok, "status field must exist on middleware generated event")
 	assert.Equal(t, http.StatusTeapot, status, "served /fail request should have status 418")
 }
+
+func TestWrapRoundTripperWithGlobalPropagator(t *testing.T) {
+	mo := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:       "placeholder",
+		Dataset:      "placeholder",
+		APIHost:      "placeholder",
+		Transmission: mo})
+	assert.Equal(t, nil, err)
+	beeline.Init(beeline.Config{
+		Client:     client,
+		Propagator: propagation.NewCompositePropagator(propagation.W3CPropagator{}, propagation.B3Propagator{}),
+	})
+	defer beeline.Init(beeline.Config{Client: client})
+
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		received = r.Header
+	}))
+	defer server.Close()
+
+	ctx, span := beeline.StartSpan(context.Background(), "root")
+	r, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	resp, err := WrapRoundTripper(http.DefaultTransport).RoundTrip(r)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	span.Send()
+
+	assert.Contains(t, received.Get("traceparent"), span.GetTrace().GetTraceID())
+	assert.Equal(t, span.GetTrace().GetTraceID(), received.Get("x-b3-traceid"))
+	assert.Empty(t, received.Get(propagation.TracePropagationHTTPHeader), "the global propagator replaces the default header")
+}
+
+func TestWrapRoundTripperClientTrace(t *testing.T) {
+	mo := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:       "placeholder",
+		Dataset:      "placeholder",
+		APIHost:      "placeholder",
+		Transmission: mo})
+	assert.Equal(t, nil, err)
+	beeline.Init(beeline.Config{Client: client})
+
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	defer server.Close()
+
+	roundTrip := func(mode config.HTTPClientTraceMode, transport http.RoundTripper) []*transmission.Event {
+		sent := len(mo.Events())
+		ctx, parent := beeline.StartSpan(context.Background(), "root")
+		r, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+		resp, err := WrapRoundTripperWithConfig(transport, config.HTTPOutgoingConfig{ClientTrace: mode}).RoundTrip(r)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		parent.Send()
+		return mo.Events()[sent:]
+	}
+	clientSpan := func(evs []*transmission.Event) map[string]interface{} {
+		for _, ev := range evs {
+			if ev.Data["meta.type"] == "http_client" {
+				return ev.Data
+			}
+		}
+		t.Fatal("no http_client span")
+		return nil
+	}
+
+	transport := &http.Transport{}
+	defer transport.CloseIdleConnections()
+	fields := clientSpan(roundTrip(config.HTTPClientTraceFields, transport))
+	for _, f := range []string{"http.get_conn_ms", "http.connect_ms", "http.write_request_ms", "http.time_to_first_byte_ms"} {
+		assert.Contains(t, fields, f)
+	}
+	assert.Equal(t, "127.0.0.1", fields["conn.remote_ip"])
+	assert.Equal(t, false, fields["conn.reused"])
+
+	evs := roundTrip(config.HTTPClientTraceEvents, transport)
+	fields = clientSpan(evs)
+	assert.Equal(t, true, fields["conn.reused"], "the second request should reuse the connection")
+	assert.Equal(t, true, fields["conn.was_idle"])
+	assert.NotContains(t, fields, "http.connect_ms")
+	var events []interface{}
+	for _, ev := range evs {
+		if ev.Data["meta.annotation_type"] == "span_event" {
+			assert.Equal(t, fields["trace.span_id"], ev.Data["trace.parent_id"])
+			events = append(events, ev.Data["name"])
+		}
+	}
+	assert.Equal(t, []interface{}{"http.get_conn", "http.write_request", "http.time_to_first_byte"}, events)
+
+	evs = roundTrip(config.HTTPClientTraceSpans, &http.Transport{})
+	fields = clientSpan(evs)
+	var phases []interface{}
+	for _, ev := range evs {
+		if ev.Data["meta.type"] == "http_client_phase" {
+			assert.Equal(t, fields["trace.span_id"], ev.Data["trace.parent_id"])
+			phases = append(phases, ev.Data["name"])
+		}
+	}
+	assert.ElementsMatch(t, []interface{}{"http.connect", "http.get_conn", "http.write_request", "http.time_to_first_byte"}, phases)
+
+	fields = clientSpan(roundTrip(config.HTTPClientTraceOff, transport))
+	assert.NotContains(t, fields, "http.get_conn_ms")
+
+	// phase timings are opt in
+	sent := len(mo.Events())
+	ctx, parent := beeline.StartSpan(context.Background(), "root")
+	r, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	resp, err := WrapRoundTripperWithConfig(transport, config.HTTPOutgoingConfig{}).RoundTrip(r)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	parent.Send()
+	for k := range clientSpan(mo.Events()[sent:]) {
+		assert.False(t, strings.HasPrefix(k, "http.") || strings.HasPrefix(k, "conn."), "unexpected field %s", k)
+	}
+}
+
+func TestWrapRoundTripperTraceResponseBody(t *testing.T) {
+	mo := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:       "placeholder",
+		Dataset:      "placeholder",
+		APIHost:      "placeholder",
+		Transmission: mo})
+	assert.Equal(t, nil, err)
+	beeline.Init(beeline.Config{Client: client})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 1000)))
+	}))
+	defer server.Close()
+
+	ctx, parent := beeline.StartSpan(context.Background(), "root")
+	defer parent.Send()
+	tripper := WrapRoundTripperWithConfig(http.DefaultTransport, config.HTTPOutgoingConfig{
+		ClientTrace:         config.HTTPClientTraceOff,
+		TraceResponseBody:   true,
+		ResponseBodyTimeout: 50 * time.Millisecond,
+	})
+
+	r, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	resp, err := tripper.RoundTrip(r)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(mo.Events()), "the span should wait for the body")
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, 1000, len(body))
+	resp.Body.Close()
+	assert.Equal(t, 1, len(mo.Events()), "the span should be sent once, at EOF")
+	fields := mo.Events()[0].Data
+	assert.Equal(t, int64(1000), fields["response.body_bytes"])
+	assert.Contains(t, fields, "response.body_read_ms")
+	assert.NotContains(t, fields, "response.body_timed_out")
+
+	// a body that is never closed
+	r, _ = http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	resp, err = tripper.RoundTrip(r)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Eventually(t, func() bool { return len(mo.Events()) == 2 }, time.Second, time.Millisecond)
+	fields = mo.Events()[1].Data
+	assert.Equal(t, true, fields["response.body_timed_out"])
+	assert.Equal(t, int64(0), fields["response.body_bytes"])
+
+	// a parent sent before the body is read, as when a helper returns the
+	// response to its caller
+	helperCtx, helper := beeline.StartSpan(ctx, "helper")
+	r, _ = http.NewRequestWithContext(helperCtx, "GET", server.URL, nil)
+	resp, err = tripper.RoundTrip(r)
+	assert.NoError(t, err)
+	helper.Send()
+	assert.Equal(t, 3, len(mo.Events()), "only the parent is sent")
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, 4, len(mo.Events()))
+	fields = mo.Events()[3].Data
+	assert.Equal(t, "http_client", fields["meta.type"])
+	assert.Equal(t, int64(1000), fields["response.body_bytes"])
+	assert.NotContains(t, fields, "meta.sent_by_parent")
+}
+
+func TestHeaderCapture(t *testing.T) {
+	mo := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:       "placeholder",
+		Dataset:      "placeholder",
+		APIHost:      "placeholder",
+		Transmission: mo})
+	assert.Equal(t, nil, err)
+	beeline.Init(beeline.Config{Client: client})
+
+	capture := config.HTTPHeaderCapture{Allow: []string{"Authorization", "X-Tenant", "X-Served-By"}}
+	handler := WrapHandlerWithConfig(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Served-By", "server-1")
+	}), config.HTTPIncomingConfig{HeaderCapture: capture})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	ctx, parent := beeline.StartSpan(context.Background(), "root")
+	r, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("X-Tenant", "acme")
+	tripper := WrapRoundTripperWithConfig(http.DefaultTransport, config.HTTPOutgoingConfig{
+		HeaderCapture: capture,
+		ClientTrace:   config.HTTPClientTraceOff,
+	})
+	resp, err := tripper.RoundTrip(r)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	parent.Send()
+
+	evs := mo.Events()
+	assert.Equal(t, 3, len(evs))
+	for _, ev := range evs[:2] {
+		// the server and client spans
+		assert.Equal(t, "acme", ev.Data["request.header.x_tenant"])
+		assert.Equal(t, "[REDACTED]", ev.Data["request.header.authorization"])
+		assert.Equal(t, "server-1", ev.Data["response.header.x_served_by"])
+	}
+}
+
+func TestBodyCapture(t *testing.T) {
+	mo := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:       "placeholder",
+		Dataset:      "placeholder",
+		APIHost:      "placeholder",
+		Transmission: mo})
+	assert.Equal(t, nil, err)
+	beeline.Init(beeline.Config{Client: client})
+
+	capture := config.HTTPBodyCapture{
+		ContentTypes: []string{"application/json"},
+		RedactKeys:   []string{"secret"},
+	}
+	handler := WrapHandlerWithConfig(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"ok":true,"secret":"s"}`)
+	}), config.HTTPIncomingConfig{BodyCapture: capture})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	ctx, parent := beeline.StartSpan(context.Background(), "root")
+	r, _ := http.NewRequestWithContext(ctx, "POST", server.URL, strings.NewReader(`{"secret":"x","n":1}`))
+	r.Header.Set("Content-Type", "application/json")
+	tripper := WrapRoundTripperWithConfig(http.DefaultTransport, config.HTTPOutgoingConfig{
+		BodyCapture: capture,
+		ClientTrace: config.HTTPClientTraceOff,
+	})
+	resp, err := tripper.RoundTrip(r)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(mo.Events()), "the client span waits for the captured response body")
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, `{"ok":true,"secret":"s"}`, string(body))
+	parent.Send()
+
+	evs := mo.Events()
+	assert.Equal(t, 3, len(evs))
+	for _, ev := range evs[:2] {
+		// the server and client spans
+		assert.Equal(t, `{"secret":"[REDACTED]","n":1}`, ev.Data["request.body"])
+		assert.Equal(t, false, ev.Data["request.body_truncated"])
+		assert.Equal(t, `{"ok":true,"secret":"[REDACTED]"}`, ev.Data["response.body"])
+	}
+}
+
+func TestWrapHandlerRoutePattern(t *testing.T) {
+	mo := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:       "placeholder",
+		Dataset:      "placeholder",
+		APIHost:      "placeholder",
+		Transmission: mo})
+	assert.Equal(t, nil, err)
+	beeline.Init(beeline.Config{Client: client})
+
+	api := http.NewServeMux()
+	api.HandleFunc("GET /api/items/{id}/files/{path...}", func(_ http.ResponseWriter, _ *http.Request) {})
+	mux := http.NewServeMux()
+	mux.Handle("/api/", api)
+	mux.HandleFunc("POST /users/{name}", WrapHandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+	mux.HandleFunc("/{$}", func(_ http.ResponseWriter, _ *http.Request) {})
+
+	t.Run("nested mux", func(t *testing.T) {
+		r, _ := http.NewRequest("HEAD", "/api/items/42/files/a/b.txt", nil)
+		WrapHandler(mux).ServeHTTP(httptest.NewRecorder(), r)
+
+		evs := mo.Events()
+		assert.Equal(t, 1, len(evs))
+		fields := evs[0].Data
+		assert.Equal(t, "HEAD /api/items/{id}/files/{path...}", fields["name"])
+		assert.Equal(t, "/api/items/{id}/files/{path...}", fields["route"])
+		assert.Equal(t, "GET /api/items/{id}/files/{path...}", fields["handler.pattern"])
+		assert.Equal(t, "42", fields["handler.vars.id"])
+		assert.Equal(t, "a/b.txt", fields["handler.vars.path"])
+	})
+
+	t.Run("handler func", func(t *testing.T) {
+		r, _ := http.NewRequest("POST", "/users/ada", nil)
+		mux.ServeHTTP(httptest.NewRecorder(), r)
+
+		evs := mo.Events()
+		assert.Equal(t, 2, len(evs))
+		fields := evs[1].Data
+		assert.Equal(t, "POST /users/{name}", fields["name"])
+		assert.Equal(t, "/users/{name}", fields["route"])
+		assert.Equal(t, "ada", fields["handler.vars.name"])
+	})
+
+	t.Run("anchored pattern", func(t *testing.T) {
+		r, _ := http.NewRequest("GET", "/", nil)
+		WrapHandler(mux).ServeHTTP(httptest.NewRecorder(), r)
+
+		evs := mo.Events()
+		assert.Equal(t, 3, len(evs))
+		fields := evs[2].Data
+		assert.Equal(t, "GET /{$}", fields["name"])
+		assert.NotContains(t, fields, "handler.vars.$")
+	})
+
+	t.Run("strip prefix", func(t *testing.T) {
+		admin := http.NewServeMux()
+		admin.HandleFunc("GET /users/{id}", WrapHandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}))
+		mux.Handle("/admin/", http.StripPrefix("/admin", admin))
+		r, _ := http.NewRequest("GET", "/admin/users/7", nil)
+		WrapHandler(mux).ServeHTTP(httptest.NewRecorder(), r)
+
+		evs := mo.Events()
+		assert.Equal(t, 5, len(evs))
+		for _, ev := range evs[3:] {
+			assert.Equal(t, "GET /users/{id}", ev.Data["name"])
+			assert.Equal(t, "/users/{id}", ev.Data["route"])
+			assert.Equal(t, "7", ev.Data["handler.vars.id"])
+		}
+		assert.Equal(t, evs[4].Data["trace.span_id"], evs[3].Data["trace.parent_id"],
+			"the outer span should get the route of the handler it contains")
+	})
+}
+
+func TestFilterAndSampleRate(t *testing.T) {
+	mo := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:       "placeholder",
+		Dataset:      "placeholder",
+		APIHost:      "placeholder",
+		Transmission: mo})
+	assert.Equal(t, nil, err)
+	beeline.Init(beeline.Config{Client: client})
+
+	var downstreamHeader string
+	downstream := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		downstreamHeader = r.Header.Get(propagation.TracePropagationHTTPHeader)
+	}))
+	defer downstream.Close()
+	tripper := WrapRoundTripperWithConfig(http.DefaultTransport, config.HTTPOutgoingConfig{
+		ClientTrace: config.HTTPClientTraceOff,
+	})
+
+	handler := WrapHandlerWithConfig(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), "GET", downstream.URL, nil)
+		resp, err := tripper.RoundTrip(req)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+		}
+	}), config.HTTPIncomingConfig{
+		Filter: func(r *http.Request) bool { return r.URL.Path != "/healthz" },
+		SampleRate: func(r *http.Request) uint {
+			if r.URL.Path == "/hot" {
+				return 4
+			}
+			return 0
+		},
+	})
+
+	t.Run("filtered requests propagate their trace", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/healthz", nil)
+		r.Header.Set(propagation.TracePropagationHTTPHeader, "1;trace_id=abcdef,parent_id=12345")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		assert.Equal(t, 0, len(mo.Events()), "no spans are sent")
+		prop, err := propagation.UnmarshalHoneycombTraceContext(downstreamHeader)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "abcdef", prop.TraceID)
+			assert.Equal(t, "12345", prop.ParentID)
+		}
+	})
+
+	t.Run("per route sample rates", func(t *testing.T) {
+		sampler, _ := sample.NewDeterministicSampler(4)
+		traceID := "0af7651916cd43dd8448eb211c80319c"
+		for i := 0; !sampler.Sample(traceID); i++ {
+			traceID = fmt.Sprintf("%032x", i)
+		}
+		r := httptest.NewRequest("GET", "/hot", nil)
+		r.Header.Set(propagation.TracePropagationHTTPHeader, "1;trace_id="+traceID+",parent_id=12345")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		evs := mo.Events()
+		assert.Equal(t, 2, len(evs))
+		for _, ev := range evs {
+			assert.Equal(t, uint(4), ev.SampleRate, "the whole trace is sampled at the route's rate")
+		}
+
+		r = httptest.NewRequest("GET", "/cold", nil)
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		evs = mo.Events()[2:]
+		assert.Equal(t, 2, len(evs))
+		assert.Equal(t, uint(1), evs[1].SampleRate)
+	})
+}
+
+func TestWrapRoundTripperDestinationPolicy(t *testing.T) {
+	mo := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:       "placeholder",
+		Dataset:      "placeholder",
+		APIHost:      "placeholder",
+		Transmission: mo})
+	assert.Equal(t, nil, err)
+	beeline.Init(beeline.Config{Client: client})
+
+	var header string
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get(propagation.TracePropagationHTTPHeader)
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		name     string
+		policy   config.DestinationPolicy
+		context  bool
+		fields   bool
+		expected interface{}
+	}{
+		{"default", config.DestinationPolicy{}, true, true, nil},
+		{"trusted", config.DestinationPolicy{Trusted: []string{"127.0.0.1"}}, true, true, nil},
+		{"allowed", config.DestinationPolicy{Allow: []string{"127.0.0.0/8"}, Trusted: []string{"*.internal"}}, true, false, "ids"},
+		{"denied", config.DestinationPolicy{Deny: []string{"127.0.0.1"}}, false, false, "none"},
+		{"not allowed", config.DestinationPolicy{Allow: []string{"*.internal"}}, false, false, "none"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			header = ""
+			ctx, parent := beeline.StartSpan(context.Background(), "root")
+			beeline.AddFieldToTrace(ctx, "user", "ada")
+			r, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+			tripper := WrapRoundTripperWithConfig(http.DefaultTransport, config.HTTPOutgoingConfig{
+				ClientTrace:       config.HTTPClientTraceOff,
+				DestinationPolicy: tc.policy,
+			})
+			resp, err := tripper.RoundTrip(r)
+			assert.NoError(t, err)
+			resp.Body.Close()
+			parent.Send()
+
+			evs := mo.Events()
+			clientSpan := evs[len(evs)-2].Data
+			assert.Equal(t, "http_client", clientSpan["meta.type"], "the client span is always recorded")
+			assert.Equal(t, tc.expected, clientSpan["meta.propagation"])
+			if !tc.context {
+				assert.Empty(t, header)
+				return
+			}
+			prop, err := propagation.UnmarshalHoneycombTraceContext(header)
+			if assert.NoError(t, err) {
+				assert.Equal(t, parent.GetTrace().GetTraceID(), prop.TraceID)
+				if tc.fields {
+					assert.Equal(t, "ada", prop.TraceContext["app.user"])
+				} else {
+					assert.Empty(t, prop.TraceContext)
+				}
+			}
+		})
+	}
+}
+
+func TestWrapHandlerTrustPolicy(t *testing.T) {
+	mo := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:       "placeholder",
+		Dataset:      "placeholder",
+		APIHost:      "placeholder",
+		Transmission: mo})
+	assert.Equal(t, nil, err)
+	beeline.Init(beeline.Config{Client: client})
+
+	handler := WrapHandlerWithConfig(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {}), config.HTTPIncomingConfig{
+		HeaderCapture: config.HTTPHeaderCapture{Allow: []string{"*"}},
+		TrustPolicy: config.TrustPolicy{
+			Networks:    []string{"10.0.0.0/8"},
+			Header:      "X-Internal-Token",
+			HeaderValue: "s3cret",
+		},
+	})
+	prop := &propagation.PropagationContext{
+		TraceID:      "abcdef",
+		ParentID:     "12345",
+		TraceContext: map[string]interface{}{"user": "mallory"},
+	}
+	newRequest := func(remoteAddr string) *http.Request {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set(propagation.TracePropagationHTTPHeader, propagation.MarshalHoneycombTraceContext(prop))
+		return r
+	}
+
+	t.Run("trusted", func(t *testing.T) {
+		offset := len(mo.Events())
+		handler.ServeHTTP(httptest.NewRecorder(), newRequest("10.1.2.3:5000"))
+		evs := mo.Events()[offset:]
+		assert.Equal(t, 1, len(evs))
+		fields := evs[0].Data
+		assert.Equal(t, "abcdef", fields["trace.trace_id"])
+		assert.Equal(t, "12345", fields["trace.parent_id"])
+		assert.Equal(t, "mallory", fields["user"])
+		assert.Nil(t, fields["meta.untrusted_upstream"])
+	})
+
+	t.Run("untrusted", func(t *testing.T) {
+		offset := len(mo.Events())
+		handler.ServeHTTP(httptest.NewRecorder(), newRequest("203.0.113.7:5000"))
+		evs := mo.Events()[offset:]
+		if assert.Equal(t, 2, len(evs), "a span and its link") {
+			span, link := evs[0].Data, evs[1].Data
+			assert.NotEqual(t, "abcdef", span["trace.trace_id"], "a new trace is started")
+			assert.Nil(t, span["trace.parent_id"])
+			assert.Nil(t, span["user"], "untrusted fields are dropped")
+			assert.Equal(t, true, span["meta.untrusted_upstream"])
+			assert.Equal(t, "link", link["meta.annotation_type"])
+			assert.Equal(t, "abcdef", link["trace.link.trace_id"])
+			assert.Equal(t, "12345", link["trace.link.span_id"])
+			assert.Equal(t, span["trace.span_id"], link["trace.parent_id"])
+		}
+	})
+
+	t.Run("trusted by header", func(t *testing.T) {
+		offset := len(mo.Events())
+		r := newRequest("203.0.113.7:5000")
+		r.Header.Set("X-Internal-Token", "s3cret")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+		evs := mo.Events()[offset:]
+		assert.Equal(t, 1, len(evs))
+		assert.Equal(t, "abcdef", evs[0].Data["trace.trace_id"])
+		assert.Equal(t, "[REDACTED]", evs[0].Data["request.header.x_internal_token"])
+	})
+}
+
+func TestFilteredRequestTraceFieldPolicy(t *testing.T) {
+	mo := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:       "placeholder",
+		Dataset:      "placeholder",
+		APIHost:      "placeholder",
+		Transmission: mo})
+	assert.Equal(t, nil, err)
+	beeline.Init(beeline.Config{
+		Client: client,
+		PropagatedTraceFields: propagation.TraceFieldPolicy{
+			Prefixes:       []string{"app."},
+			MaxHeaderBytes: 200,
+		},
+	})
+	defer func() { propagation.GlobalConfig.TraceFields = propagation.TraceFieldPolicy{} }()
+
+	var downstreamHeader string
+	downstream := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		downstreamHeader = r.Header.Get(propagation.TracePropagationHTTPHeader)
+	}))
+	defer downstream.Close()
+	tripper := WrapRoundTripperWithConfig(http.DefaultTransport, config.HTTPOutgoingConfig{
+		ClientTrace: config.HTTPClientTraceOff,
+	})
+	handler := WrapHandlerWithConfig(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), "GET", downstream.URL, nil)
+		resp, err := tripper.RoundTrip(req)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+		}
+	}), config.HTTPIncomingConfig{
+		Filter: func(r *http.Request) bool { return r.URL.Path != "/healthz" },
+	})
+
+	r := httptest.NewRequest("GET", "/healthz", nil)
+	r.Header.Set(propagation.TracePropagationHTTPHeader, propagation.MarshalHoneycombTraceContext(&propagation.PropagationContext{
+		TraceID:  "abcdef",
+		ParentID: "12345",
+		TraceContext: map[string]interface{}{
+			"app.user":  "ada",
+			"app.notes": strings.Repeat("x", 500),
+			"session":   "s3cret",
+		},
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	assert.LessOrEqual(t, len(downstreamHeader), 200)
+	prop, err := propagation.UnmarshalHoneycombTraceContext(downstreamHeader)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "abcdef", prop.TraceID)
+		assert.Equal(t, map[string]interface{}{"app.user": "ada"}, prop.TraceContext)
+	}
+	assert.Equal(t, 0, len(mo.Events()), "no spans are sent")
+}
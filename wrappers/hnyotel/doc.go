@@ -0,0 +1,26 @@
+// Package hnyotel provides an OpenTelemetry TracerProvider backed by the
+// beeline, so that spans from libraries instrumented with OpenTelemetry join
+// beeline traces.
+//
+// Usage
+//
+// Create a TracerProvider and install it as the OpenTelemetry global, or hand
+// it to the libraries that take one:
+//
+//     otel.SetTracerProvider(hnyotel.NewTracerProvider())
+//
+// OpenTelemetry spans started with a context carrying a beeline span become
+// children of that span, and beeline spans started under an OpenTelemetry
+// span from this provider become its children. An OpenTelemetry span with no
+// parent starts a new beeline trace, continuing any remote span context found
+// in the context.
+//
+// Attributes become fields on the span, with the span kind in `span.kind` and
+// the tracer's name and version in `library.name` and `library.version`.
+// Events and recorded errors become span events, links become beeline links,
+// and an error status sets the `error`, `status_code` and `status_message`
+// fields.
+//
+// Explicit start and end timestamps are ignored; spans are timed by the
+// beeline from the moment they are started until they end.
+package hnyotel
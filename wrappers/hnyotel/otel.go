@@ -0,0 +1,219 @@
+package hnyotel
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+
+	"github.com/honeycombio/beeline-go/propagation"
+	"github.com/honeycombio/beeline-go/trace"
+)
+
+// TracerProvider is an OpenTelemetry TracerProvider whose spans are beeline
+// spans.
+type TracerProvider struct {
+	embedded.TracerProvider
+}
+
+// NewTracerProvider returns a TracerProvider sending spans through the beeline.
+// The beeline must be initialized with beeline.Init for the spans to be sent.
+func NewTracerProvider() *TracerProvider {
+	return &TracerProvider{}
+}
+
+// Tracer returns a Tracer for the named instrumentation library.
+func (tp *TracerProvider) Tracer(name string, opts ...oteltrace.TracerOption) oteltrace.Tracer {
+	cfg := oteltrace.NewTracerConfig(opts...)
+	return &tracer{
+		provider: tp,
+		name:     name,
+		version:  cfg.InstrumentationVersion(),
+	}
+}
+
+type tracer struct {
+	embedded.Tracer
+
+	provider *TracerProvider
+	name     string
+	version  string
+}
+
+// Start creates a beeline span, as a child of the beeline span in ctx if there
+// is one, and returns a context carrying it both as a beeline span and as an
+// OpenTelemetry span.
+func (t *tracer) Start(ctx context.Context, name string, opts ...oteltrace.SpanStartOption) (context.Context, oteltrace.Span) {
+	cfg := oteltrace.NewSpanStartConfig(opts...)
+
+	var bs *trace.Span
+	parent := trace.GetSpanFromContext(ctx)
+	if parent != nil && !cfg.NewRoot() {
+		ctx, bs = parent.CreateChild(ctx)
+	} else {
+		var prop *propagation.PropagationContext
+		if sc := oteltrace.SpanContextFromContext(ctx); sc.IsValid() && sc.IsRemote() && !cfg.NewRoot() {
+			prop = &propagation.PropagationContext{
+				TraceID:  sc.TraceID().String(),
+				ParentID: sc.SpanID().String(),
+			}
+			prop.TraceState, _ = propagation.ParseTraceState(sc.TraceState().String())
+		}
+		var tr *trace.Trace
+		ctx, tr = trace.NewTrace(ctx, prop)
+		bs = tr.GetRootSpan()
+	}
+
+	s := &span{
+		tracer: t,
+		span:   bs,
+		sc:     spanContext(bs),
+	}
+	fields := map[string]interface{}{
+		"name":         name,
+		"span.kind":    cfg.SpanKind().String(),
+		"library.name": t.name,
+	}
+	if t.version != "" {
+		fields["library.version"] = t.version
+	}
+	addAttributes(fields, cfg.Attributes())
+	bs.AddFields(fields)
+	for _, link := range cfg.Links() {
+		s.AddLink(link)
+	}
+
+	return oteltrace.ContextWithSpan(ctx, s), s
+}
+
+// spanContext returns the OpenTelemetry SpanContext of a beeline span. It is
+// invalid if the beeline IDs are not in the W3C format, as is possible for
+// traces continued from other header formats.
+func spanContext(bs *trace.Span) oteltrace.SpanContext {
+	cfg := oteltrace.SpanContextConfig{TraceFlags: oteltrace.FlagsSampled}
+	cfg.TraceID, _ = oteltrace.TraceIDFromHex(bs.GetTrace().GetTraceID())
+	cfg.SpanID, _ = oteltrace.SpanIDFromHex(bs.GetSpanID())
+	cfg.TraceState, _ = oteltrace.ParseTraceState(bs.GetTrace().GetTraceState().String())
+	return oteltrace.NewSpanContext(cfg)
+}
+
+func addAttributes(fields map[string]interface{}, attrs []attribute.KeyValue) {
+	for _, kv := range attrs {
+		fields[string(kv.Key)] = kv.Value.AsInterface()
+	}
+}
+
+type span struct {
+	embedded.Span
+
+	tracer *tracer
+	span   *trace.Span
+	sc     oteltrace.SpanContext
+
+	lock   sync.Mutex
+	ended  bool
+	status codes.Code
+}
+
+// End sends the beeline span.
+func (s *span) End(options ...oteltrace.SpanEndOption) {
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.lock.Unlock()
+	s.span.Send()
+}
+
+// AddEvent adds a span event to the beeline span.
+func (s *span) AddEvent(name string, options ...oteltrace.EventOption) {
+	cfg := oteltrace.NewEventConfig(options...)
+	fields := make(map[string]interface{})
+	addAttributes(fields, cfg.Attributes())
+	s.span.AddSpanEvent(name, fields)
+}
+
+// AddLink adds a beeline link to the linked span.
+func (s *span) AddLink(link oteltrace.Link) {
+	if !link.SpanContext.IsValid() {
+		return
+	}
+	fields := make(map[string]interface{})
+	addAttributes(fields, link.Attributes)
+	s.span.AddLink(link.SpanContext.TraceID().String(), link.SpanContext.SpanID().String(), fields)
+}
+
+// IsRecording reports whether the span has not ended yet.
+func (s *span) IsRecording() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return !s.ended
+}
+
+// RecordError adds an `exception` span event describing err.
+func (s *span) RecordError(err error, options ...oteltrace.EventOption) {
+	if err == nil {
+		return
+	}
+	cfg := oteltrace.NewEventConfig(options...)
+	fields := map[string]interface{}{
+		"exception.type":    fmt.Sprintf("%T", err),
+		"exception.message": err.Error(),
+	}
+	addAttributes(fields, cfg.Attributes())
+	s.span.AddSpanEvent("exception", fields)
+}
+
+func (s *span) SpanContext() oteltrace.SpanContext {
+	return s.sc
+}
+
+// otlpStatusCodes maps status codes to the numbers used for them in OTLP, so
+// that `status_code` matches spans sent by the OpenTelemetry SDK.
+var otlpStatusCodes = map[codes.Code]int{
+	codes.Unset: 0,
+	codes.Ok:    1,
+	codes.Error: 2,
+}
+
+// SetStatus sets the `status_code` field, and for errors the `error` and
+// `status_message` fields. As in OpenTelemetry, Ok overrides Error, which
+// overrides Unset.
+func (s *span) SetStatus(code codes.Code, description string) {
+	s.lock.Lock()
+	if code <= s.status {
+		s.lock.Unlock()
+		return
+	}
+	s.status = code
+	s.lock.Unlock()
+
+	s.span.AddField("status_code", otlpStatusCodes[code])
+	if code == codes.Error {
+		if description == "" {
+			description = code.String()
+		}
+		s.span.AddField("error", description)
+		s.span.AddField("status_message", description)
+	}
+}
+
+func (s *span) SetName(name string) {
+	s.span.AddField("name", name)
+}
+
+func (s *span) SetAttributes(kv ...attribute.KeyValue) {
+	fields := make(map[string]interface{}, len(kv))
+	addAttributes(fields, kv)
+	s.span.AddFields(fields)
+}
+
+func (s *span) TracerProvider() oteltrace.TracerProvider {
+	return s.tracer.provider
+}
@@ -0,0 +1,101 @@
+package hnyotel
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+
+	"github.com/honeycombio/beeline-go"
+	libhoney "github.com/honeycombio/libhoney-go"
+	"github.com/honeycombio/libhoney-go/transmission"
+)
+
+func setupLibhoney(t *testing.T) *transmission.MockSender {
+	mo := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:       "placeholder",
+		Dataset:      "placeholder",
+		APIHost:      "placeholder",
+		Transmission: mo,
+	})
+	assert.Equal(t, nil, err)
+	beeline.Init(beeline.Config{Client: client})
+	return mo
+}
+
+func TestOTelSpansJoinBeelineTraces(t *testing.T) {
+	mo := setupLibhoney(t)
+	otel.SetTracerProvider(NewTracerProvider())
+	tracer := otel.Tracer("github.com/example/lib", oteltrace.WithInstrumentationVersion("v1.2.3"))
+
+	ctx, root := beeline.StartSpan(context.Background(), "root")
+	ctx, os := tracer.Start(ctx, "otel", oteltrace.WithSpanKind(oteltrace.SpanKindClient),
+		oteltrace.WithAttributes(attribute.String("db.system", "postgres")))
+	_, leaf := beeline.StartSpan(ctx, "leaf")
+	leaf.Send()
+	os.SetAttributes(attribute.Int("rows", 3))
+	os.AddEvent("retry", oteltrace.WithAttributes(attribute.Int("attempt", 2)))
+	os.RecordError(errors.New("boom"))
+	os.SetStatus(codes.Error, "query failed")
+	os.End()
+	assert.False(t, os.IsRecording())
+	root.Send()
+
+	events := mo.Events()
+	assert.Equal(t, 5, len(events))
+	byName := make(map[string]map[string]interface{})
+	for _, ev := range events {
+		byName[ev.Data["name"].(string)] = ev.Data
+	}
+	rootID := root.GetSpanID()
+	otelSpan := byName["otel"]
+	assert.Equal(t, rootID, otelSpan["trace.parent_id"], "otel span should be a child of the beeline span")
+	assert.Equal(t, os.SpanContext().SpanID().String(), otelSpan["trace.span_id"])
+	assert.Equal(t, os.SpanContext().TraceID().String(), otelSpan["trace.trace_id"])
+	assert.Equal(t, otelSpan["trace.span_id"], byName["leaf"]["trace.parent_id"], "beeline span should be a child of the otel span")
+	assert.Equal(t, "client", otelSpan["span.kind"])
+	assert.Equal(t, "github.com/example/lib", otelSpan["library.name"])
+	assert.Equal(t, "v1.2.3", otelSpan["library.version"])
+	assert.Equal(t, "postgres", otelSpan["db.system"])
+	assert.Equal(t, int64(3), otelSpan["rows"])
+	assert.Equal(t, 2, otelSpan["status_code"])
+	assert.Equal(t, "query failed", otelSpan["error"])
+
+	assert.Equal(t, "span_event", byName["retry"]["meta.annotation_type"])
+	assert.Equal(t, int64(2), byName["retry"]["attempt"])
+	assert.Equal(t, "boom", byName["exception"]["exception.message"])
+	assert.Equal(t, otelSpan["trace.span_id"], byName["exception"]["trace.parent_id"])
+}
+
+func TestOTelRootSpan(t *testing.T) {
+	mo := setupLibhoney(t)
+	tracer := NewTracerProvider().Tracer("lib")
+
+	remote := oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+		TraceID:    oteltrace.TraceID{1, 2, 3},
+		SpanID:     oteltrace.SpanID{4, 5, 6},
+		TraceFlags: oteltrace.FlagsSampled,
+		Remote:     true,
+	})
+	linked := oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+		TraceID: oteltrace.TraceID{7},
+		SpanID:  oteltrace.SpanID{8},
+	})
+	ctx := oteltrace.ContextWithRemoteSpanContext(context.Background(), remote)
+	_, s := tracer.Start(ctx, "consume", oteltrace.WithLinks(oteltrace.Link{SpanContext: linked}))
+	s.End()
+
+	events := mo.Events()
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, remote.TraceID().String(), events[0].Data["trace.trace_id"])
+	assert.Equal(t, remote.SpanID().String(), events[0].Data["trace.parent_id"])
+	assert.Equal(t, "link", events[1].Data["meta.annotation_type"])
+	assert.Equal(t, linked.TraceID().String(), events[1].Data["trace.link.trace_id"])
+	assert.Equal(t, linked.SpanID().String(), events[1].Data["trace.link.span_id"])
+}
@@ -0,0 +1,17 @@
+// Package hnyslog wraps a `log/slog` handler so that logs can be joined to
+// beeline traces.
+//
+// Usage
+//
+// Wrap the handler you already log with, and make sure to use the
+// context-aware logging functions with the context of the current request:
+//
+//     logger := slog.New(hnyslog.NewHandler(slog.NewJSONHandler(os.Stdout, nil), nil))
+//     logger.InfoContext(r.Context(), "fetched flavors", "count", n)
+//
+// Every record logged with a context that carries a span gets
+// `trace.trace_id` and `trace.span_id` attributes. The handler can also copy
+// records onto the span, either as span events or as fields, and marks the
+// span as errored for error level logs. Use HandlerOptions.ReplaceAttr to keep
+// secrets that are fine in logs out of spans.
+package hnyslog
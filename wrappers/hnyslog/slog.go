@@ -0,0 +1,197 @@
+package hnyslog
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/honeycombio/beeline-go/trace"
+)
+
+// HandlerOptions configures how log records are copied onto the active span.
+// The zero value only adds the trace and span IDs to records and marks the
+// span as errored for error level logs.
+type HandlerOptions struct {
+	// SpanEvents, when true, records each log line as a span event on the
+	// active span, named after the log message.
+	SpanEvents bool
+
+	// FieldsLevel, if set, adds the attributes of records at or above this
+	// level directly to the active span as fields, along with `log.message`
+	// and `log.level`. If several records are logged on the same span, the
+	// last one wins.
+	FieldsLevel slog.Leveler
+
+	// ErrorLevel is the level at or above which a record marks the active span
+	// as errored by setting its `error` field to the log message.
+	// default: slog.LevelError
+	ErrorLevel slog.Leveler
+
+	// ReplaceAttr is called on each attribute before it is copied onto a span,
+	// with the groups it is in. Return a zero Attr to keep it out of the span.
+	// It does not change what is logged. Attributes are copied to spans with
+	// an `app.` prefix and groups joined with dots, eg `app.req.user`.
+	ReplaceAttr func(groups []string, a slog.Attr) slog.Attr
+}
+
+// Handler is a slog.Handler that correlates log records with the active
+// beeline span before passing them on to the wrapped handler.
+type Handler struct {
+	next slog.Handler
+	// base is the wrapped handler before any WithAttrs or WithGroup, and
+	// scopes are the calls made since, so that the trace and span IDs can be
+	// added outside of any groups.
+	base   slog.Handler
+	scopes []scope
+	opts   HandlerOptions
+	groups []string
+	// fields are the attributes added with WithAttrs, already filtered and
+	// flattened for copying onto spans.
+	fields map[string]interface{}
+}
+
+// scope is a call to WithGroup, if group is set, or to WithAttrs.
+type scope struct {
+	group string
+	attrs []slog.Attr
+}
+
+// NewHandler returns a Handler that wraps next. opts may be nil.
+func NewHandler(next slog.Handler, opts *HandlerOptions) *Handler {
+	h := &Handler{next: next, base: next}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.ErrorLevel == nil {
+		h.opts.ErrorLevel = slog.LevelError
+	}
+	return h
+}
+
+// Enabled reports whether the wrapped handler handles records at the given
+// level.
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+// Handle copies the record onto the span in ctx, if there is one, adds the
+// trace and span IDs to the record and passes it on to the wrapped handler.
+// The IDs are always top level attributes, even when the logger has groups.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	span := trace.GetSpanFromContext(ctx)
+	if span == nil {
+		return h.next.Handle(ctx, r)
+	}
+
+	if h.opts.SpanEvents || h.opts.FieldsLevel != nil || r.Level >= h.opts.ErrorLevel.Level() {
+		fields := make(map[string]interface{}, len(h.fields)+r.NumAttrs())
+		for k, v := range h.fields {
+			fields[k] = v
+		}
+		r.Attrs(func(a slog.Attr) bool {
+			h.addAttr(fields, h.groups, a)
+			return true
+		})
+		if h.opts.SpanEvents {
+			event := make(map[string]interface{}, len(fields)+1)
+			for k, v := range fields {
+				event[k] = v
+			}
+			event["log.level"] = r.Level.String()
+			span.AddSpanEvent(r.Message, event)
+		}
+		if h.opts.FieldsLevel != nil && r.Level >= h.opts.FieldsLevel.Level() {
+			fields["log.message"] = r.Message
+			fields["log.level"] = r.Level.String()
+			span.AddFields(fields)
+		}
+		if r.Level >= h.opts.ErrorLevel.Level() {
+			span.AddField("error", r.Message)
+		}
+	}
+
+	ids := []slog.Attr{
+		slog.String("trace.trace_id", span.GetTrace().GetTraceID()),
+		slog.String("trace.span_id", span.GetSpanID()),
+	}
+	if len(h.groups) == 0 {
+		r = r.Clone()
+		r.AddAttrs(ids...)
+		return h.next.Handle(ctx, r)
+	}
+	// attributes of the record would land in the innermost group, so add the
+	// IDs before the groups are opened
+	next := h.base.WithAttrs(ids)
+	for _, sc := range h.scopes {
+		if sc.group != "" {
+			next = next.WithGroup(sc.group)
+		} else {
+			next = next.WithAttrs(sc.attrs)
+		}
+	}
+	return next.Handle(ctx, r)
+}
+
+// WithAttrs returns a Handler whose records, and the spans they are copied
+// onto, include the given attributes.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := h.clone()
+	h2.next = h.next.WithAttrs(attrs)
+	h2.scopes = append(h2.scopes[:len(h2.scopes):len(h2.scopes)], scope{attrs: attrs})
+	for _, a := range attrs {
+		h2.addAttr(h2.fields, h2.groups, a)
+	}
+	return h2
+}
+
+// WithGroup returns a Handler that puts the attributes that follow in the
+// named group.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := h.clone()
+	h2.next = h.next.WithGroup(name)
+	h2.scopes = append(h2.scopes[:len(h2.scopes):len(h2.scopes)], scope{group: name})
+	h2.groups = append(h2.groups[:len(h2.groups):len(h2.groups)], name)
+	return h2
+}
+
+func (h *Handler) clone() *Handler {
+	h2 := *h
+	h2.fields = make(map[string]interface{}, len(h.fields))
+	for k, v := range h.fields {
+		h2.fields[k] = v
+	}
+	return &h2
+}
+
+// addAttr flattens an attribute into span fields, applying ReplaceAttr.
+func (h *Handler) addAttr(fields map[string]interface{}, groups []string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() == slog.KindGroup {
+		attrs := a.Value.Group()
+		if len(attrs) == 0 {
+			return
+		}
+		// inline groups with no key, matching slog's own handlers
+		if a.Key != "" {
+			groups = append(groups[:len(groups):len(groups)], a.Key)
+		}
+		for _, ga := range attrs {
+			h.addAttr(fields, groups, ga)
+		}
+		return
+	}
+	if h.opts.ReplaceAttr != nil {
+		a = h.opts.ReplaceAttr(groups, a)
+		a.Value = a.Value.Resolve()
+	}
+	if a.Equal(slog.Attr{}) || a.Key == "" {
+		return
+	}
+	key := "app."
+	for _, g := range groups {
+		key += g + "."
+	}
+	fields[key+a.Key] = a.Value.Any()
+}
@@ -0,0 +1,113 @@
+package hnyslog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/honeycombio/beeline-go"
+	libhoney "github.com/honeycombio/libhoney-go"
+	"github.com/honeycombio/libhoney-go/transmission"
+)
+
+func setupLibhoney(t *testing.T) *transmission.MockSender {
+	mo := &transmission.MockSender{}
+	client, err := libhoney.NewClient(libhoney.ClientConfig{
+		APIKey:       "placeholder",
+		Dataset:      "placeholder",
+		APIHost:      "placeholder",
+		Transmission: mo,
+	})
+	assert.Equal(t, nil, err)
+	beeline.Init(beeline.Config{Client: client})
+	return mo
+}
+
+func TestHandlerAddsTraceIDs(t *testing.T) {
+	mo := setupLibhoney(t)
+	var buf bytes.Buffer
+	logger := slog.New(NewHandler(slog.NewTextHandler(&buf, nil), nil))
+
+	ctx, span := beeline.StartSpan(context.Background(), "root")
+	logger.InfoContext(ctx, "hello", "flavor", "rose")
+	span.Send()
+
+	out := buf.String()
+	assert.Contains(t, out, "trace.trace_id="+span.GetTrace().GetTraceID())
+	assert.Contains(t, out, "trace.span_id="+span.GetSpanID())
+	assert.Contains(t, out, "flavor=rose")
+
+	events := mo.Events()
+	assert.Equal(t, 1, len(events), "info logs should not be copied to the span by default")
+	assert.NotContains(t, events[0].Data, "app.flavor")
+	assert.NotContains(t, events[0].Data, "error")
+
+	// logging without a span just passes the record on
+	buf.Reset()
+	logger.Info("no span")
+	assert.NotContains(t, buf.String(), "trace.trace_id")
+}
+
+func TestHandlerAddsTraceIDsOutsideGroups(t *testing.T) {
+	setupLibhoney(t)
+	var buf bytes.Buffer
+	logger := slog.New(NewHandler(slog.NewJSONHandler(&buf, nil), nil))
+	logger = logger.With("component", "shop").WithGroup("req").With("method", "GET")
+
+	ctx, span := beeline.StartSpan(context.Background(), "root")
+	logger.InfoContext(ctx, "fetching", "user", "ben")
+	span.Send()
+
+	var record map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, span.GetTrace().GetTraceID(), record["trace.trace_id"])
+	assert.Equal(t, span.GetSpanID(), record["trace.span_id"])
+	assert.Equal(t, "shop", record["component"])
+	assert.Equal(t, map[string]interface{}{"method": "GET", "user": "ben"}, record["req"])
+}
+
+func TestHandlerCopiesToSpan(t *testing.T) {
+	mo := setupLibhoney(t)
+	var buf bytes.Buffer
+	logger := slog.New(NewHandler(slog.NewTextHandler(&buf, nil), &HandlerOptions{
+		SpanEvents:  true,
+		FieldsLevel: slog.LevelWarn,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == "password" {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	logger = logger.With("component", "shop").WithGroup("req")
+
+	ctx, span := beeline.StartSpan(context.Background(), "root")
+	logger.InfoContext(ctx, "fetching", "user", "ben", "password", "hunter2")
+	logger.ErrorContext(ctx, "fetch failed", "err", errors.New("boom"))
+	span.Send()
+
+	assert.Contains(t, buf.String(), "req.password=hunter2", "filtering should not change the logs")
+
+	events := mo.Events()
+	assert.Equal(t, 3, len(events))
+	root := events[0].Data
+	assert.Equal(t, "fetch failed", root["error"])
+	assert.Equal(t, "fetch failed", root["log.message"])
+	assert.Equal(t, "shop", root["app.component"])
+	assert.Equal(t, "boom", root["app.req.err"])
+	assert.NotContains(t, root, "app.req.user", "info logs are below the fields level")
+
+	info := events[1].Data
+	assert.Equal(t, "fetching", info["name"])
+	assert.Equal(t, "span_event", info["meta.annotation_type"])
+	assert.Equal(t, span.GetSpanID(), info["trace.parent_id"])
+	assert.Equal(t, "INFO", info["log.level"])
+	assert.Equal(t, "ben", info["app.req.user"])
+	assert.NotContains(t, info, "app.req.password")
+	assert.Equal(t, "fetch failed", events[2].Data["name"])
+}